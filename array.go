@@ -0,0 +1,89 @@
+package jsonl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AppendElement appends v to the JSON array held by the latest
+// entry, writing the extended array as a new entry. An empty store
+// is treated as holding an empty array. This gives array-append
+// semantics to stores which keep a whole dataset in one entry, with
+// the previous array recoverable should the write fail. The read and
+// write are made under one lock. The array extended is the entry as
+// stored, before any WithReadTransform(), and the result is written as
+// Write() would write it, transformed and checked against the size
+// limit.
+func (j *Jsonl) AppendElement(v any) error {
+	elem, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = j.write(func() ([]byte, error) {
+		latest, err := j.readStored("AppendElement")
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrNoValidEntry) {
+			latest = []byte("[]")
+		} else if err != nil {
+			return nil, err
+		}
+		if len(latest) < 2 || latest[0] != '[' {
+			return nil, fmt.Errorf("jsonl: latest entry is not a JSON array")
+		}
+		body := bytes.TrimSpace(latest[1 : len(latest)-1])
+		p := make([]byte, 0, len(body)+len(elem)+4)
+		p = append(p, '[')
+		if len(body) > 0 {
+			p = append(append(p, body...), ',')
+		}
+		p = append(append(p, elem...), ']')
+		// The array is written as Write() would write it.
+		return j.prepareLimit(p, j.cfg.maxEntrySize())
+	})
+	return err
+}
+
+// Flatten rewrites the store so that each element of the JSON array
+// held by the latest entry is an entry of its own, in order,
+// returning the number of elements. The history before the latest
+// entry is discarded. If the latest entry is not an array, or the
+// store is empty, an error is returned and the store is unchanged.
+// The file is rewritten atomically.
+func (j *Jsonl) Flatten() (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	elems := 0
+	err := j.rewrite("Flatten", func(view view, emit func(src int64, entry []byte) error) error {
+		var arr []json.RawMessage
+		err := view.latest(func(entry []byte) error {
+			if entry[0] != '[' {
+				return fmt.Errorf("jsonl: latest entry is not a JSON array")
+			}
+			return json.Unmarshal(entry, &arr)
+		})
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrNoValidEntry) {
+			return ErrEmpty
+		}
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		for _, elem := range arr {
+			buf.Reset()
+			if err := json.Compact(&buf, elem); err != nil {
+				return err
+			}
+			if err := emit(-1, buf.Bytes()); err != nil {
+				return err
+			}
+			elems++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return elems, nil
+}
@@ -0,0 +1,141 @@
+package jsonl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAppendElement(t *testing.T) {
+	store := openTemp(t, "array.jsonl")
+	type element struct {
+		Number int `json:"number"`
+	}
+	for i := 0; i < 3; i++ {
+		if err := store.AppendElement(element{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"number":0},{"number":1},{"number":2}]`; string(latest) != want {
+		t.Fatalf("got wrong entry from AppendElement(). Expected (%s), got (%s)", want, latest)
+	}
+	var elements []element
+	if err := store.Decode(&elements); err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 3 {
+		t.Fatalf("expected a 3-element array, got (%d) elements", len(elements))
+	}
+	if n, _ := store.Count(); n != 3 {
+		t.Fatalf("expected each append to write an entry, got (%d) entries", n)
+	}
+
+	// A manually written array may hold whitespace.
+	writeEntries(t, store, `[ ]`)
+	if err := store.AppendElement(json.RawMessage(`"a"`)); err != nil {
+		t.Fatal(err)
+	}
+	if latest, _ := store.ReadBytes(); string(latest) != `["a"]` {
+		t.Fatalf("got wrong entry from AppendElement(). Expected (%s), got (%s)", `["a"]`, latest)
+	}
+	writeEntries(t, store, `{"number":0}`)
+	if err := store.AppendElement(1); err == nil {
+		t.Fatal("expected an error appending to an object")
+	}
+}
+
+func TestAppendElementPrepared(t *testing.T) {
+	// The write transform redacts secrets, and the read transform hides
+	// every entry, which must not lose the stored elements.
+	redact := func(entry []byte) ([]byte, error) {
+		return bytes.ReplaceAll(entry, []byte(`"secret"`), []byte(`"hidden"`)), nil
+	}
+	hide := func([]byte) ([]byte, error) {
+		return nil, nil
+	}
+	store := openTemp(t, "array.jsonl", WithWriteTransform(redact), WithReadTransform(hide), WithWriteAlignment(32))
+	for _, v := range []any{0, "secret"} {
+		if err := store.AppendElement(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(store.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[0,"hidden"]`; !strings.Contains(string(b), want+" ") || len(b)%32 != 0 {
+		t.Fatalf("expected the array written transformed and aligned as (%s), got (%q)", want, b)
+	}
+
+	limited := openTemp(t, "limited.jsonl", WithMaxMemory(16))
+	if err := limited.AppendElement(strings.Repeat("x", 16)); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected ErrEntryTooLarge, got (%v)", err)
+	}
+	rejecting := openTemp(t, "rejecting.jsonl", WithRejectNull(), WithWriteTransform(func([]byte) ([]byte, error) {
+		return []byte("null"), nil
+	}))
+	if err := rejecting.AppendElement(0); !errors.Is(err, ErrNotJSON) {
+		t.Fatalf("expected ErrNotJSON, got (%v)", err)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	store := openTemp(t, "flatten.jsonl")
+	if _, err := store.Flatten(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty flattening an empty store, got (%v)", err)
+	}
+	writeEntries(t, store, `{"number":0}`)
+	if _, err := store.Flatten(); err == nil {
+		t.Fatal("expected an error flattening an object")
+	}
+	writeEntries(t, store, `[{"number":1}]`, `[{"number":1}, {"number":2},{"number":3},{ "number":4 }]`)
+	n, err := store.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("expected (%d) elements from Flatten(), got (%d)", 4, n)
+	}
+	got, err := collect(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"number":1},{"number":2},{"number":3},{"number":4}`
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got wrong entries after Flatten(). Expected (%s), got (%s)", want, strings.Join(got, ","))
+	}
+}
+
+func TestFlattenWithSidecar(t *testing.T) {
+	store := openTemp(t, "flatten.jsonl", WithSidecar())
+	writeEntries(t, store, `{"number":0}`, `[{"number":1},{"number":2},{"number":3}]`)
+	n, err := store.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected (%d) elements from Flatten(), got (%d)", 3, n)
+	}
+	// The elements derive from no single entry, so have no metadata.
+	for i := 0; i < n; i++ {
+		if _, err := store.Meta(i); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected fs.ErrNotExist for flattened entry (%d), got (%v)", i, err)
+		}
+	}
+	writeEntries(t, store, `{"number":4}`)
+	m, err := store.Meta(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Index != 3 || m.WrittenAt.IsZero() {
+		t.Fatalf("got wrong metadata for entry (%d), got (%+v)", 3, m)
+	}
+}
@@ -0,0 +1,287 @@
+package jsonl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+	"sort"
+)
+
+// CompareAndWrite appends entry only if the latest entry is equal
+// to expectedLatest, reporting whether it was written. Entries are
+// compared canonically, so formatting and object key order don't
+// matter, or by the WithComparator() function. An empty
+// expectedLatest matches an empty store. The comparison and write
+// are made under one lock, giving optimistic concurrency to writers
+// updating the same store.
+func (j *Jsonl) CompareAndWrite(expectedLatest []byte, entry []byte) (bool, error) {
+	p, err := j.prepare(entry)
+	if err != nil {
+		return false, err
+	}
+	want := bytes.TrimSpace(expectedLatest)
+	if len(want) > 0 && !validJSON(want) {
+		return false, ErrNotJSON
+	}
+	swapped := false
+	_, err = j.write(func() ([]byte, error) {
+		swapped = false
+		latest, err := j.readLatest("CompareAndWrite")
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrNoValidEntry) {
+			latest = nil
+		} else if err != nil {
+			return nil, err
+		}
+		equal := latest == nil && len(want) == 0
+		if latest != nil && len(want) > 0 {
+			if equal, err = j.equal(latest, want); err != nil {
+				return nil, err
+			}
+		}
+		if !equal {
+			return nil, nil
+		}
+		swapped = true
+		return p, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+// AppendIfChanged appends entry unless it is equal to the latest
+// entry, reporting whether it was written, so that writers recording
+// state on a timer don't fill the store with duplicates. Entries are
+// compared as by CompareAndWrite(), under one lock with the write.
+func (j *Jsonl) AppendIfChanged(entry []byte) (bool, error) {
+	p, err := j.prepare(entry)
+	if err != nil {
+		return false, err
+	}
+	appended := false
+	_, err = j.write(func() ([]byte, error) {
+		appended = false
+		latest, err := j.readLatest("AppendIfChanged")
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrNoValidEntry) {
+			latest = nil
+		} else if err != nil {
+			return nil, err
+		}
+		if latest != nil {
+			// Compare the entry as it would be read back, as the
+			// latest entry is.
+			read, err := j.readTransform(append([]byte(nil), bytes.TrimSpace(p)...))
+			if err != nil {
+				return nil, err
+			}
+			equal, err := j.equal(latest, read)
+			if err != nil || equal {
+				return nil, err
+			}
+		}
+		appended = true
+		return p, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return appended, nil
+}
+
+// equal reports whether the entries a and b are equal, using the
+// WithComparator() function if any, or otherwise comparing them
+// canonically.
+func (j *Jsonl) equal(a, b []byte) (bool, error) {
+	if j.cfg.comparator != nil {
+		var equal bool
+		err := call(func() error {
+			equal = j.cfg.comparator(a, b)
+			return nil
+		})
+		return equal, err
+	}
+	ca, err := canonical(a)
+	if err != nil {
+		return false, err
+	}
+	cb, err := canonical(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ca, cb), nil
+}
+
+// Consistent reports whether the file is as this handle last saw it.
+// It returns false if another handle or process has written to the
+// file since this handle last wrote to it or read its latest entry,
+// or has replaced it, such as by compacting it, leaving this handle
+// with the old file. Writers sharing a store may check it to detect
+// a split brain before a write which depends on what they last read.
+// The file must be named.
+func (j *Jsonl) Consistent() (bool, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.name == "" {
+		return false, fmt.Errorf("jsonl: checking consistency requires a named file: %w", errors.ErrUnsupported)
+	}
+	ours, err := j.stat("Consistent")
+	if err != nil {
+		return false, err
+	}
+	fresh, err := os.Stat(j.name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(ours, fresh) && fresh.Size() == j.seen.Load(), nil
+}
+
+// LatestHash returns the hex encoded SHA-256 hash of the canonical
+// encoding of the latest entry, or ErrEmpty if the store does not
+// contain any entries. Polling it detects changes to the latest
+// value cheaply, as rewriting an equal value leaves it unchanged.
+func (j *Jsonl) LatestHash() (string, error) {
+	j.mu.RLock()
+	latest, err := j.readLatest("LatestHash")
+	j.mu.RUnlock()
+	if errors.Is(err, io.EOF) {
+		return "", ErrEmpty
+	}
+	if err != nil {
+		return "", err
+	}
+	c, err := canonical(latest)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(c)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// FileHash returns the hex encoded SHA-256 hash of every non-corrupt
+// entry in the file as stored, before any WithReadTransform(), each
+// followed by a newline. Corrupt lines and a partial write at the end
+// of the file don't affect it.
+func (j *Jsonl) FileHash() (string, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	view, err := j.view("FileHash")
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	err = view.stored().entries(func(_ int64, entry []byte) error {
+		h.Write(entry)
+		h.Write([]byte("\n"))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Delta lists the top-level fields which differ between two
+// consecutive entries, the versions From and To.
+type Delta struct {
+	From, To int
+	// Changed holds the names of the fields added, removed or
+	// changed, sorted.
+	Changed []string
+}
+
+// Deltas returns an iterator over the changes between each pair of
+// consecutive non-corrupt entries, oldest first, giving a timeline of
+// what changed. Values are compared canonically. Entries must be JSON
+// objects; a step involving any other entry yields an error wrapping
+// ErrNotJSON with its Delta, and iteration continues. If reading the
+// file fails, the error is yielded and iteration stops.
+func (j *Jsonl) Deltas() iter.Seq2[Delta, error] {
+	return func(yield func(Delta, error) bool) {
+		view, release, err := j.snapshot("Deltas")
+		if err != nil {
+			yield(Delta{}, err)
+			return
+		}
+		defer release()
+		var prev map[string]json.RawMessage
+		var prevErr error
+		version := -1
+		err = view.entries(func(_ int64, entry []byte) error {
+			version++
+			fields, err := objectFields(entry)
+			if version > 0 {
+				delta := Delta{From: version - 1, To: version}
+				stepErr := errors.Join(prevErr, err)
+				if stepErr == nil {
+					delta.Changed = changedFields(prev, fields)
+				}
+				if !yield(delta, stepErr) {
+					return errStop
+				}
+			}
+			prev, prevErr = fields, err
+			return nil
+		})
+		if err != nil {
+			yield(Delta{}, err)
+		}
+	}
+}
+
+// objectFields returns the canonical encoding of each top-level field
+// of the JSON object entry.
+func objectFields(entry []byte) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(entry, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("%w: entry %q is not an object", ErrNotJSON, entry)
+	}
+	for k, v := range fields {
+		c, err := canonical(v)
+		if err != nil {
+			return nil, err
+		}
+		fields[k] = c
+	}
+	return fields, nil
+}
+
+// changedFields returns the sorted names of the fields which differ
+// between a and b.
+func changedFields(a, b map[string]json.RawMessage) []string {
+	var changed []string
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// canonical returns the canonical encoding of the JSON value p,
+// with insignificant whitespace removed and object keys sorted.
+func canonical(p []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, ErrNotJSON
+	}
+	return json.Marshal(v)
+}
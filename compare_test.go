@@ -0,0 +1,232 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareAndWrite(t *testing.T) {
+	store := openTemp(t, "cas.jsonl")
+
+	// An empty expectation matches an empty store.
+	ok, err := store.CompareAndWrite(nil, []byte(`{"a":1,"b":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected CompareAndWrite() to succeed on an empty store")
+	}
+
+	// A stale expectation fails, and nothing is written.
+	ok, err = store.CompareAndWrite([]byte(`{"a":0}`), []byte(`{"a":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected CompareAndWrite() to fail with a stale expectation")
+	}
+	count, err := store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected Count() of (%d), got (%d)", 1, count)
+	}
+
+	// A matching expectation succeeds regardless of key order and
+	// whitespace.
+	ok, err = store.CompareAndWrite([]byte(`{ "b": 2, "a": 1 }`), []byte(`{"a":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected CompareAndWrite() to succeed with a matching expectation")
+	}
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"a":3}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"a":3}`, latest)
+	}
+}
+
+func TestHashes(t *testing.T) {
+	store := openTemp(t, "hash.jsonl")
+	if _, err := store.LatestHash(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty from an empty store, got (%v)", err)
+	}
+	writeEntries(t, store, `{"a":1,"b":2}`)
+	latest, err := store.LatestHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := store.FileHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An equal value leaves the latest hash unchanged.
+	writeEntries(t, store, `{ "b": 2, "a": 1 }`)
+	if h, _ := store.LatestHash(); h != latest {
+		t.Fatalf("expected an equal value to hash the same, got (%s) and (%s)", latest, h)
+	}
+	if h, _ := store.FileHash(); h == file {
+		t.Fatal("expected the file hash to change after a write")
+	}
+	// A corrupt tail leaves the file hash unchanged.
+	file, _ = store.FileHash()
+	if _, err := store.f.Write([]byte(`{"a":`)); err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := store.FileHash(); h != file {
+		t.Fatal("expected a corrupt tail not to change the file hash")
+	}
+	writeEntries(t, store, `{"a":2,"b":2}`)
+	if h, _ := store.LatestHash(); h == latest {
+		t.Fatal("expected a differing value to change the latest hash")
+	}
+}
+
+func TestDeltas(t *testing.T) {
+	store := openTemp(t, "deltas.jsonl")
+	writeEntries(t, store,
+		`{"a":1,"b":{"x":1,"y":2}}`,
+		`{"a":2,"b":{"y":2,"x":1}}`,
+		`{"a":2,"b":{"x":1},"c":true}`,
+		`{"c":true}`,
+		`[1]`,
+		`{"c":false}`,
+	)
+	want := []struct {
+		changed string
+		err     bool
+	}{
+		{"a", false},
+		{"b,c", false},
+		{"a,b", false},
+		{"", true},
+		{"", true},
+	}
+	i := 0
+	for delta, err := range store.Deltas() {
+		if i >= len(want) {
+			t.Fatalf("got too many deltas, got (%+v)", delta)
+		}
+		if delta.From != i || delta.To != i+1 {
+			t.Fatalf("got wrong versions for delta (%d), got (%+v)", i, delta)
+		}
+		if (err != nil) != want[i].err || (err != nil && !errors.Is(err, ErrNotJSON)) {
+			t.Fatalf("got wrong error for delta (%d), got (%v)", i, err)
+		}
+		if got := strings.Join(delta.Changed, ","); got != want[i].changed {
+			t.Fatalf("got wrong fields for delta (%d). Expected (%s), got (%s)", i, want[i].changed, got)
+		}
+		i++
+	}
+	if i != len(want) {
+		t.Fatalf("expected (%d) deltas, got (%d)", len(want), i)
+	}
+}
+
+func TestAppendIfChanged(t *testing.T) {
+	store := openTemp(t, "changed.jsonl")
+	for _, tc := range []struct {
+		entry string
+		want  bool
+	}{
+		{`{"a":1,"b":2}`, true},
+		{`{"b":2, "a":1}`, false},
+		{`{"a":2,"b":2}`, true},
+	} {
+		appended, err := store.AppendIfChanged([]byte(tc.entry))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if appended != tc.want {
+			t.Fatalf("got wrong result from AppendIfChanged(%s). Expected (%t), got (%t)", tc.entry, tc.want, appended)
+		}
+	}
+}
+
+func TestWithComparator(t *testing.T) {
+	// Compare entries ignoring their volatile timestamp.
+	ignoreTimestamp := func(a, b []byte) bool {
+		var va, vb map[string]any
+		if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+			return false
+		}
+		delete(va, "timestamp")
+		delete(vb, "timestamp")
+		return reflect.DeepEqual(va, vb)
+	}
+	store, err := OpenFile(filepath.Join(t.TempDir(), "comparator.jsonl"), WithComparator(ignoreTimestamp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, tc := range []struct {
+		entry string
+		want  bool
+	}{
+		{`{"state":"on","timestamp":1}`, true},
+		{`{"state":"on","timestamp":2}`, false},
+		{`{"state":"off","timestamp":3}`, true},
+	} {
+		appended, err := store.AppendIfChanged([]byte(tc.entry))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if appended != tc.want {
+			t.Fatalf("got wrong result from AppendIfChanged(%s). Expected (%t), got (%t)", tc.entry, tc.want, appended)
+		}
+	}
+	ok, err := store.CompareAndWrite([]byte(`{"state":"off","timestamp":0}`), []byte(`{"state":"on","timestamp":4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected CompareAndWrite() to ignore the timestamp")
+	}
+}
+
+func TestConsistent(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "consistent.jsonl")
+	first, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	check := func(want bool) {
+		t.Helper()
+		ok, err := first.Consistent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Fatalf("got wrong result from Consistent(). Expected (%t), got (%t)", want, ok)
+		}
+	}
+	writeEntries(t, first, `{"number":0}`)
+	check(true)
+	// Another handle writes.
+	writeEntries(t, second, `{"number":1}`)
+	check(false)
+	if _, err := first.ReadBytes(); err != nil {
+		t.Fatal(err)
+	}
+	check(true)
+	// Another handle replaces the file.
+	if err := second.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check(false)
+}
@@ -0,0 +1,67 @@
+package jsonl
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+)
+
+// directBlock is the alignment of direct IO writes, which covers the
+// logical block size of common devices.
+const directBlock = 4096
+
+// directFile is a File which makes appends of whole blocks at aligned
+// offsets through a second descriptor opened for direct IO. Other
+// writes and all reads use the buffered file.
+type directFile struct {
+	*os.File
+	direct *os.File
+}
+
+// wrapDirect returns f wrapped to append with direct IO if opened
+// WithDirectIO(), or f if direct IO is not enabled or unsupported.
+func (j *Jsonl) wrapDirect(f *os.File) File {
+	if !j.cfg.directIO {
+		return f
+	}
+	direct, err := openDirect(f.Name())
+	if err != nil {
+		if j.cfg.log != nil {
+			j.cfg.log.Warn("jsonl: direct IO unsupported, writing through the page cache", "error", err)
+		}
+		return f
+	}
+	return &directFile{File: f, direct: direct}
+}
+
+func (f *directFile) Write(p []byte) (int, error) {
+	if len(p)%directBlock != 0 {
+		return f.File.Write(p)
+	}
+	// The caller holds the file lock, so the size is stable.
+	stat, err := f.File.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if stat.Size()%directBlock != 0 {
+		return f.File.Write(p)
+	}
+	buf := alignedBuffer(len(p))
+	copy(buf, p)
+	return f.direct.WriteAt(buf, stat.Size())
+}
+
+func (f *directFile) Close() error {
+	return errors.Join(f.direct.Close(), f.File.Close())
+}
+
+// alignedBuffer returns a buffer of n bytes whose address is aligned
+// to directBlock, as direct IO requires.
+func alignedBuffer(n int) []byte {
+	b := make([]byte, n+directBlock)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&b[0])) % directBlock); rem != 0 {
+		off = directBlock - rem
+	}
+	return b[off : off+n : off+n]
+}
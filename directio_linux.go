@@ -0,0 +1,14 @@
+//go:build linux
+
+package jsonl
+
+import (
+	"os"
+	"syscall"
+)
+
+// openDirect opens name for writing with direct IO. Filesystems which
+// don't support it, such as tmpfs, fail with EINVAL.
+func openDirect(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_WRONLY|syscall.O_DIRECT, 0)
+}
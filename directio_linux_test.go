@@ -0,0 +1,64 @@
+//go:build linux
+
+package jsonl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithDirectIO(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "direct.jsonl")
+	store, err := OpenFile(filename, WithDirectIO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, direct := store.f.(*directFile)
+	if !direct {
+		t.Logf("direct IO unsupported by the filesystem, testing the fallback")
+	}
+	var want []string
+	for i := 0; i < 3; i++ {
+		entry := fmt.Sprintf(`{"number":%d}`, i)
+		writeEntries(t, store, entry)
+		want = append(want, entry)
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != 3*directBlock {
+		t.Fatalf("expected (%d) bytes of aligned entries, got (%d)", 3*directBlock, stat.Size())
+	}
+	got, err := collect(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got wrong entries from All(). Expected (%v), got (%v)", want, got)
+	}
+	// Entries remain aligned after a rewrite.
+	writeEntries(t, store, `{"number":3}`)
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":4}`)
+	if b, err := store.ReadBytes(); err != nil || string(b) != `{"number":4}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", `{"number":4}`, b, err)
+	}
+	if stat, err := os.Stat(filename); err != nil || stat.Size() != 2*directBlock {
+		t.Fatalf("expected (%d) bytes after Compact(), got (%v, %v)", 2*directBlock, stat, err)
+	}
+
+	// A lazily opened store appends with direct IO too.
+	lazy := NewLazy(filepath.Join(t.TempDir(), "lazy.jsonl"), WithDirectIO())
+	defer lazy.Close()
+	writeEntries(t, lazy, `{"number":0}`)
+	if _, ok := lazy.f.(*lazyFile).f.(*directFile); ok != direct {
+		t.Fatalf("expected NewLazy() to use direct IO as OpenFile() does (%v), got (%v)", direct, ok)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package jsonl
+
+import (
+	"errors"
+	"os"
+)
+
+// openDirect is unsupported on this platform, so writes go through
+// the page cache.
+func openDirect(name string) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,132 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ExportCSV writes the entries to w as CSV, oldest first, with a
+// header row of columns followed by a row per entry holding the
+// values of those top-level fields. Missing and null fields are
+// empty cells, and nested objects and arrays are JSON-encoded.
+// Entries which are not JSON objects are skipped.
+func (j *Jsonl) ExportCSV(w io.Writer, columns []string) error {
+	view, release, err := j.snapshot("ExportCSV")
+	if err != nil {
+		return err
+	}
+	defer release()
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	row := make([]string, len(columns))
+	err = view.entries(func(_ int64, entry []byte) error {
+		dec := json.NewDecoder(bytes.NewReader(entry))
+		dec.UseNumber()
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err != nil {
+			return nil
+		}
+		for i, column := range columns {
+			cell, err := csvCell(obj[column])
+			if err != nil {
+				return err
+			}
+			row[i] = cell
+		}
+		return cw.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell formats a decoded JSON value as a CSV cell.
+func csvCell(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	default:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
+
+// SplitInto copies the entries into the new stores at paths, oldest
+// first, such as to shard a store for parallel processing. Each entry
+// goes to paths[assign(entry) % len(paths)]. The files must not
+// already exist, and are removed if the split fails. Corrupt entries
+// and the header, if any, are not copied, and entries are copied as
+// stored, before any WithReadTransform(). assign may be called while
+// the store is being written to, but must not use the store.
+func (j *Jsonl) SplitInto(paths []string, assign func(entry []byte) int) (err error) {
+	if len(paths) == 0 {
+		return fmt.Errorf("jsonl: no paths to split into")
+	}
+	view, release, err := j.snapshot("SplitInto")
+	if err != nil {
+		return err
+	}
+	defer release()
+	files := make([]*os.File, 0, len(paths))
+	defer func() {
+		for _, f := range files {
+			err = errors.Join(err, f.Close())
+		}
+		if err != nil {
+			for _, f := range files {
+				_ = os.Remove(f.Name())
+			}
+		}
+	}()
+	writers := make([]*bufio.Writer, len(paths))
+	for i, path := range paths {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return fmt.Errorf("jsonl failed to create shard %q: %w", path, err)
+		}
+		files = append(files, f)
+		writers[i] = bufio.NewWriter(f)
+	}
+	err = view.stored().entries(func(_ int64, entry []byte) error {
+		var n int
+		if err := call(func() error { n = assign(entry); return nil }); err != nil {
+			return err
+		}
+		n %= len(paths)
+		if n < 0 {
+			n += len(paths)
+		}
+		if _, err := writers[n].Write(entry); err != nil {
+			return err
+		}
+		return writers[n].WriteByte('\n')
+	})
+	if err != nil {
+		return err
+	}
+	for i, w := range writers {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := files[i].Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package jsonl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportCSV(t *testing.T) {
+	store := openTemp(t, "export.jsonl")
+	writeEntries(t, store,
+		`{"name":"a","count":1,"ok":true}`,
+		`{"name":"b, with a comma","tags":["x","y"],"meta":{"k":"v"}}`,
+		`[1,2,3]`,
+		`{"name":null,"count":2.50}`,
+	)
+	var buf bytes.Buffer
+	if err := store.ExportCSV(&buf, []string{"name", "count", "ok", "tags", "meta"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "name,count,ok,tags,meta\n" +
+		"a,1,true,,\n" +
+		"\"b, with a comma\",,,\"[\"\"x\"\",\"\"y\"\"]\",\"{\"\"k\"\":\"\"v\"\"}\"\n" +
+		",2.50,,,\n"
+	if buf.String() != want {
+		t.Fatalf("got wrong CSV. Expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
+
+func TestSplitInto(t *testing.T) {
+	store := openTemp(t, "split.jsonl")
+	for i := 0; i < 9; i++ {
+		writeEntries(t, store, fmt.Sprintf(`{"number":%d}`, i))
+	}
+	dir := t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "0.jsonl"),
+		filepath.Join(dir, "1.jsonl"),
+		filepath.Join(dir, "2.jsonl"),
+	}
+	err := store.SplitInto(paths, func(entry []byte) int {
+		var v struct{ Number int }
+		if err := json.Unmarshal(entry, &v); err != nil {
+			t.Error(err)
+		}
+		return v.Number
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range paths {
+		shard, err := OpenFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer shard.Close()
+		got, err := collect(shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			fmt.Sprintf(`{"number":%d}`, i),
+			fmt.Sprintf(`{"number":%d}`, i+3),
+			fmt.Sprintf(`{"number":%d}`, i+6),
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("got wrong entries in shard %d. Expected (%v), got (%v)", i, want, got)
+		}
+	}
+	// Existing files are not overwritten, and no shards are left behind.
+	paths = []string{filepath.Join(dir, "new.jsonl"), paths[0]}
+	if err := store.SplitInto(paths, func([]byte) int { return 0 }); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected fs.ErrExist, got (%v)", err)
+	}
+	if _, err := os.Stat(paths[0]); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the new shard to be removed, got (%v)", err)
+	}
+}
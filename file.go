@@ -0,0 +1,118 @@
+package jsonl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// File is the storage backing a *Jsonl. It is implemented by
+// *os.File, and may be implemented by other backends such as
+// network-backed files.
+type File interface {
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+	Sync() error
+}
+
+var _ File = &os.File{}
+
+// lostFile stands in for a file the store has lost, such as one it
+// failed to reopen after replacing it, failing every operation with
+// err so that writes aren't silently made to the replaced file.
+type lostFile struct {
+	err error
+}
+
+func (f lostFile) ReadAt([]byte, int64) (int, error) {
+	return 0, f.err
+}
+
+func (f lostFile) Write([]byte) (int, error) {
+	return 0, f.err
+}
+
+func (f lostFile) WriteAt([]byte, int64) (int, error) {
+	return 0, f.err
+}
+
+func (f lostFile) Close() error {
+	return nil
+}
+
+func (f lostFile) Stat() (os.FileInfo, error) {
+	return nil, f.err
+}
+
+func (f lostFile) Sync() error {
+	return f.err
+}
+
+// ErrReadOnly is returned when writing to a read-only store.
+var ErrReadOnly = fmt.Errorf("jsonl: store is read-only")
+
+// OpenReader reads r until EOF and returns a read-only *Jsonl over
+// its contents, which are held in memory. It is used for sources
+// which can't be read at arbitrary offsets, such as pipes and
+// standard input. If r is an io.Closer it is closed by Close().
+// Write() returns ErrReadOnly.
+func OpenReader(r io.Reader, opts ...Option) (*Jsonl, error) {
+	if r == nil {
+		return nil, os.ErrNotExist
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("jsonl failed reading the source: %w", err)
+	}
+	m := &memFile{Reader: bytes.NewReader(b)}
+	if c, ok := r.(io.Closer); ok {
+		m.closer = c
+	}
+	return open(m, opts...)
+}
+
+// memFile is a read-only File held in memory.
+type memFile struct {
+	*bytes.Reader
+	closer io.Closer
+}
+
+func (m *memFile) Write([]byte) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (m *memFile) WriteAt([]byte, int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (m *memFile) Stat() (os.FileInfo, error) {
+	return memInfo{size: m.Size()}, nil
+}
+
+func (m *memFile) Sync() error {
+	return nil
+}
+
+func (m *memFile) Close() error {
+	if m.closer == nil {
+		return nil
+	}
+	return m.closer.Close()
+}
+
+// memInfo describes a memFile.
+type memInfo struct {
+	size int64
+}
+
+func (i memInfo) Name() string       { return "" }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() os.FileMode  { return 0o400 }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return false }
+func (i memInfo) Sys() interface{}   { return nil }
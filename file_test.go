@@ -0,0 +1,459 @@
+package jsonl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenReaderPipe(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write([]byte("{\"number\":0}\n{\"number\":1}\n{\"number\":"))
+		_ = pw.Close()
+	}()
+	store, err := OpenReader(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"number":1}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":1}`, latest)
+	}
+	if _, err := store.Write([]byte(`{"number":2}`)); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly writing to a pipe, got (%v)", err)
+	}
+}
+
+func TestOpenNonSeekableFile(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = w.Write([]byte("{\"number\":0}\n{\"number\":1}\n"))
+		_ = w.Close()
+	}()
+	store, err := Open(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"number":1}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":1}`, latest)
+	}
+}
+
+// deadlineFile is a File whose reads block until its read deadline
+// passes, like a stalled network-backed file.
+type deadlineFile struct {
+	*os.File
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (f *deadlineFile) SetReadDeadline(t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deadline = t
+	return nil
+}
+
+func (f *deadlineFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	deadline := f.deadline
+	f.mu.Unlock()
+	if deadline.IsZero() {
+		return f.File.ReadAt(p, off)
+	}
+	time.Sleep(time.Until(deadline))
+	return 0, os.ErrDeadlineExceeded
+}
+
+func TestWithReadDeadline(t *testing.T) {
+	plain := openTemp(t, "deadline.jsonl")
+	writeEntries(t, plain, `{"number":0}`)
+
+	backend := &deadlineFile{File: plain.f.(*os.File)}
+	store, err := Open(backend, WithReadDeadline(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := store.ReadBytes(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded, got (%v)", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("read took (%s) despite the deadline", elapsed)
+	}
+	if !backend.deadline.IsZero() {
+		t.Fatal("expected the read deadline to be cleared after the read")
+	}
+
+	// Regular files don't support deadlines, so the option is a no-op.
+	regular, err := Open(plain.f, WithReadDeadline(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := regular.ReadBytes(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fullFile is a File which writes part of the first write then
+// fails with ENOSPC, as if the disk filled up mid-write.
+type fullFile struct {
+	*os.File
+	full bool
+}
+
+func (f *fullFile) Write(p []byte) (int, error) {
+	if f.full {
+		f.full = false
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+	}
+	return f.File.Write(p)
+}
+
+func TestWithDiskFullHook(t *testing.T) {
+	plain := openTemp(t, "full.jsonl")
+	writeEntries(t, plain, `{"number":0}`)
+
+	backend := &fullFile{File: plain.f.(*os.File), full: true}
+	calls := 0
+	store, err := Open(backend, WithDiskFullHook(func() error {
+		calls++
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":1}`)
+	if calls != 1 {
+		t.Fatalf("expected the hook to be called (%d) times, got (%d)", 1, calls)
+	}
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"number":1}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":1}`, latest)
+	}
+
+	// Without a hook, or if the hook fails, ErrDiskFull is returned.
+	backend.full = true
+	store, err = Open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Write([]byte(`{"number":2}`)); !errors.Is(err, ErrDiskFull) || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected ErrDiskFull wrapping ENOSPC, got (%v)", err)
+	}
+	backend.full = true
+	store, err = Open(backend, WithDiskFullHook(func() error {
+		return errors.New("nothing to free")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Write([]byte(`{"number":3}`)); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("expected ErrDiskFull, got (%v)", err)
+	}
+}
+
+// shortFile is a File which writes only part of each write without
+// returning an error, as io.Writer permits.
+type shortFile struct {
+	*os.File
+}
+
+func (f *shortFile) Write(p []byte) (int, error) {
+	return f.File.Write(p[:len(p)/2])
+}
+
+func TestShortWrite(t *testing.T) {
+	plain := openTemp(t, "short.jsonl")
+	writeEntries(t, plain, `{"number":0}`)
+
+	store, err := Open(&shortFile{File: plain.f.(*os.File)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Write([]byte(`{"number":1}`))
+	if !errors.Is(err, ErrShortWrite) || !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("expected ErrShortWrite, got (%v)", err)
+	}
+	// The incomplete entry is not returned.
+	latest, err := plain.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"number":0}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":0}`, latest)
+	}
+	// And the next full write recovers from it.
+	writeEntries(t, plain, `{"number":2}`)
+	count, err := plain.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected Count() of (%d), got (%d)", 2, count)
+	}
+}
+
+// slowSyncFile is a File whose syncs block for delay, like a failing
+// storage device.
+type slowSyncFile struct {
+	*os.File
+	delay time.Duration
+}
+
+func (f *slowSyncFile) Sync() error {
+	time.Sleep(f.delay)
+	return f.File.Sync()
+}
+
+func TestWithSyncTimeout(t *testing.T) {
+	plain := openTemp(t, "sync.jsonl")
+	backend := &slowSyncFile{File: plain.f.(*os.File), delay: time.Second}
+	store, err := Open(backend, WithSyncTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := store.Write([]byte(`{"number":0}`)); !errors.Is(err, ErrSyncTimeout) {
+		t.Fatalf("expected ErrSyncTimeout, got (%v)", err)
+	}
+	if elapsed := time.Since(start); elapsed >= backend.delay {
+		t.Fatalf("write took (%s) despite the sync timeout", elapsed)
+	}
+	// The entry was written, although it may not be durable.
+	if b, err := store.ReadBytes(); err != nil || string(b) != `{"number":0}` {
+		t.Fatalf("expected the entry to be written, got (%s, %v)", b, err)
+	}
+}
+
+// failSyncFile is a File whose syncs fail while fail is set.
+type failSyncFile struct {
+	*os.File
+	fail atomic.Bool
+}
+
+func (f *failSyncFile) Sync() error {
+	if f.fail.Load() {
+		return fmt.Errorf("sync: %w", syscall.EIO)
+	}
+	return f.File.Sync()
+}
+
+func TestDurableOffset(t *testing.T) {
+	plain := openTemp(t, "durable.jsonl")
+	writeEntries(t, plain, `{"number":0}`)
+	backend := &failSyncFile{File: plain.f.(*os.File)}
+	store, err := Open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := func() int64 {
+		t.Helper()
+		stat, err := backend.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stat.Size()
+	}
+	if got, want := store.DurableOffset(), size(); got != want {
+		t.Fatalf("got wrong durable offset on open. Expected (%d), got (%d)", want, got)
+	}
+	writeEntries(t, store, `{"number":1}`)
+	if got, want := store.DurableOffset(), size(); got != want {
+		t.Fatalf("got wrong durable offset after a synced write. Expected (%d), got (%d)", want, got)
+	}
+	synced := size()
+	backend.fail.Store(true)
+	if _, err := store.Write([]byte(`{"number":2}`)); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected the sync to fail, got (%v)", err)
+	}
+	if got := store.DurableOffset(); got != synced || got >= size() {
+		t.Fatalf("expected the durable offset to lag the file size (%d) at (%d), got (%d)", size(), synced, got)
+	}
+	if err := store.Sync(); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected the sync to fail, got (%v)", err)
+	}
+	backend.fail.Store(false)
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := store.DurableOffset(), size(); got != want {
+		t.Fatalf("got wrong durable offset after Sync(). Expected (%d), got (%d)", want, got)
+	}
+}
+
+// noSyncFile is a File which doesn't support syncing.
+type noSyncFile struct {
+	*os.File
+}
+
+func (f *noSyncFile) Sync() error {
+	return fmt.Errorf("sync: %w", errors.ErrUnsupported)
+}
+
+func TestWithCloseTimeout(t *testing.T) {
+	plain := openTemp(t, "close.jsonl")
+	backend := &slowSyncFile{File: plain.f.(*os.File), delay: time.Second}
+	store, err := Open(backend, WithCloseTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := store.Close(); !errors.Is(err, ErrCloseTimeout) {
+		t.Fatalf("expected ErrCloseTimeout, got (%v)", err)
+	}
+	if elapsed := time.Since(start); elapsed >= backend.delay {
+		t.Fatalf("close took (%s) despite the close timeout", elapsed)
+	}
+	// The file descriptor has been released.
+	if _, err := backend.File.Write([]byte("{}\n")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected the file to be closed, got (%v)", err)
+	}
+	// Without a hang, Close() syncs and returns normally.
+	store, err = OpenFile(filepath.Join(t.TempDir(), "close.jsonl"), WithCloseTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Write([]byte(`{"number":0}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flakyFile is a File whose reads fail with EIO the first failures
+// times.
+type flakyFile struct {
+	*os.File
+	mu       sync.Mutex
+	failures int
+}
+
+func (f *flakyFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return 0, syscall.EIO
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func TestWithReadRetry(t *testing.T) {
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+	plain := openTemp(t, "flaky.jsonl")
+	writeEntries(t, plain, `{"number":0}`)
+	backend := &flakyFile{File: plain.f.(*os.File)}
+	store, err := Open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.failures = 1
+	if _, err := store.ReadBytes(); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected EIO without retries, got (%v)", err)
+	}
+	store, err = Open(backend, WithReadRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.failures = 2
+	if b, err := store.ReadBytes(); err != nil || string(b) != `{"number":0}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", `{"number":0}`, b, err)
+	}
+	if len(slept) != 2 || slept[0] != time.Millisecond || slept[1] != 2*time.Millisecond {
+		t.Fatalf("expected backoffs of (1ms, 2ms), got (%v)", slept)
+	}
+	// Reads fail once the attempts are exhausted.
+	backend.failures = 3
+	if _, err := store.ReadBytes(); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected EIO after exhausting retries, got (%v)", err)
+	}
+}
+
+func TestUnsupportedSync(t *testing.T) {
+	plain := openTemp(t, "nosync.jsonl")
+	var log bytes.Buffer
+	store, err := Open(&noSyncFile{File: plain.f.(*os.File)}, WithLogger(slog.New(slog.NewTextHandler(&log, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":0}`)
+	if !strings.Contains(log.String(), "sync unsupported") {
+		t.Fatalf("expected a warning to be logged, got (%q)", log.String())
+	}
+	if b, _ := store.ReadBytes(); string(b) != `{"number":0}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":0}`, b)
+	}
+}
+
+// statFailFile is a File whose Stat() fails.
+type statFailFile struct {
+	*os.File
+}
+
+func (f *statFailFile) Stat() (os.FileInfo, error) {
+	return nil, &os.PathError{Op: "stat", Path: f.Name(), Err: syscall.EIO}
+}
+
+func TestStatErrors(t *testing.T) {
+	store := openTemp(t, "stat.jsonl")
+	writeEntries(t, store, `{"number":0}`)
+	if err := store.f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var pathErr *os.PathError
+	var opErr *OpError
+	_, err := store.Read(make([]byte, 64))
+	if !errors.As(err, &pathErr) || !errors.Is(err, os.ErrClosed) || !errors.As(err, &opErr) || opErr.Op != "Read" {
+		t.Fatalf("expected a wrapped stat error from Read(), got (%v)", err)
+	}
+	var v struct{}
+	if err := store.Decode(&v); !errors.As(err, &pathErr) || !errors.As(err, &opErr) || opErr.Op != "Decode" {
+		t.Fatalf("expected a wrapped stat error from Decode(), got (%v)", err)
+	}
+	if _, err := store.Count(); !errors.As(err, &opErr) || opErr.Op != "Count" {
+		t.Fatalf("expected a wrapped stat error from Count(), got (%v)", err)
+	}
+
+	plain := openTemp(t, "statwrite.jsonl")
+	store = &Jsonl{f: &statFailFile{File: plain.f.(*os.File)}, mu: plain.mu, changed: plain.changed, count: -1}
+	_, err = store.Write([]byte(`{"number":1}`))
+	if !errors.As(err, &pathErr) || !errors.Is(err, syscall.EIO) || !errors.As(err, &opErr) || opErr.Op != "Write" {
+		t.Fatalf("expected a wrapped stat error from Write(), got (%v)", err)
+	}
+	// Rewrites wrap their errors too.
+	err = store.Compact()
+	if !errors.Is(err, errors.ErrUnsupported) || !errors.As(err, &opErr) || opErr.Op != "Compact" {
+		t.Fatalf("expected an *OpError from Compact(), got (%v)", err)
+	}
+}
@@ -0,0 +1,83 @@
+package jsonl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// formatVersion is the on-disk format version recorded in format
+// headers.
+const formatVersion = 1
+
+// headerKey is the key of the single field of a format header,
+// chosen so as not to be mistaken for an entry.
+const headerKey = "$jsonl"
+
+// header is recorded in the format header of a file.
+type header struct {
+	Version int `json:"version"`
+}
+
+// formatHeader detects a format header at the start of the jsonl
+// data, excluding it from the entries, or writes one if WithHeader()
+// was given and the file is empty.
+func (j *Jsonl) formatHeader() error {
+	view, err := j.view("open")
+	if err != nil {
+		return err
+	}
+	line := make([]byte, chunkSize)
+	n, err := view.f.ReadAt(line, view.start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("jsonl failed to read the format header: %w", err)
+	}
+	if hdr, size, ok := parseHeader(line[:n]); ok {
+		if hdr.Version > formatVersion {
+			return fmt.Errorf("jsonl: unsupported format version %d", hdr.Version)
+		}
+		j.cfg.headerBytes += size
+		return nil
+	}
+	if !j.cfg.writeHeader {
+		return nil
+	}
+	if view.size > view.start {
+		return fmt.Errorf("jsonl: cannot add a format header to a file with existing entries")
+	}
+	b, err := json.Marshal(map[string]header{headerKey: {Version: formatVersion}})
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if j.cfg.noAppend {
+		_, err = j.f.WriteAt(b, view.start)
+	} else {
+		_, err = j.f.Write(b)
+	}
+	if err != nil {
+		return fmt.Errorf("jsonl failed to write the format header: %w", err)
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.cfg.headerBytes += int64(len(b))
+	return nil
+}
+
+// parseHeader returns the format header that data begins with and the
+// size of its line, if data begins with one.
+func parseHeader(data []byte) (header, int64, bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return header{}, 0, false
+	}
+	var h map[string]header
+	if err := json.Unmarshal(data[:i], &h); err != nil || len(h) != 1 {
+		return header{}, 0, false
+	}
+	hdr, ok := h[headerKey]
+	return hdr, int64(i) + 1, ok
+}
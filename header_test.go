@@ -0,0 +1,71 @@
+package jsonl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithHeader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "headered.jsonl")
+	store, err := OpenFile(filename, WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "{\"$jsonl\":{\"version\":1}}\n") {
+		t.Fatalf("expected a format header, got (%q)", b)
+	}
+
+	// The header is detected without the option.
+	store, err = OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	count, err := store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected Count() of (%d) excluding the header, got (%d)", 2, count)
+	}
+	if store.cfg.headerBytes != int64(len("{\"$jsonl\":{\"version\":1}}\n")) {
+		t.Fatalf("expected the header to be detected, got header size (%d)", store.cfg.headerBytes)
+	}
+	// Reopening with the option doesn't write a second header.
+	again, err := OpenFile(filename, WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = again.Close()
+	if c, err := store.Count(); err != nil || c != 2 {
+		t.Fatalf("expected Count() of (%d) after reopening, got (%d, %v)", 2, c, err)
+	}
+
+	// Files without a header remain plain JSONL, but can't gain one.
+	plain := filepath.Join(t.TempDir(), "plain.jsonl")
+	if err := os.WriteFile(plain, []byte("{\"number\":0}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(plain, WithHeader()); err == nil {
+		t.Fatal("expected an error adding a header to a file with entries")
+	}
+
+	// Headers from newer versions are rejected.
+	future := filepath.Join(t.TempDir(), "future.jsonl")
+	if err := os.WriteFile(future, []byte("{\"$jsonl\":{\"version\":99}}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(future); err == nil {
+		t.Fatal("expected an error opening a file with a newer format version")
+	}
+}
@@ -0,0 +1,95 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// importBatchBytes is the size of the batches in which ImportFile()
+// appends entries, each with a single write and sync.
+const importBatchBytes = 1 << 20
+
+// ImportFile appends the entries of the jsonl file at srcPath, such
+// as one produced by another tool, to dst, returning the number of
+// entries imported. Each entry is compacted as by Write(), and blank
+// lines are skipped. The file is streamed and appended in batches,
+// so if a line is invalid the entries before it remain appended, and
+// the error reports the line number. Use ImportFileStrict() to import
+// all of the entries or none.
+func ImportFile(dst *Jsonl, srcPath string) (imported int, err error) {
+	return importFile(dst, srcPath, importBatchBytes)
+}
+
+// ImportFileStrict is like ImportFile(), but validates the whole file
+// before appending any of it, then appends every entry in a single
+// write and sync. If a line is invalid nothing is imported. The
+// entries are held in memory until they're written.
+func ImportFileStrict(dst *Jsonl, srcPath string) (imported int, err error) {
+	return importFile(dst, srcPath, -1)
+}
+
+// importFile imports srcPath into dst, appending whenever batch bytes
+// of entries have been read, or only at the end if batch is negative.
+func importFile(dst *Jsonl, srcPath string, batch int) (imported int, err error) {
+	if dst == nil {
+		return 0, os.ErrNotExist
+	}
+	dst.mu.RLock()
+	missing := dst.f == nil
+	dst.mu.RUnlock()
+	if missing {
+		return 0, os.ErrNotExist
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	var (
+		p       []byte
+		pending int
+	)
+	flush := func() error {
+		if len(p) == 0 {
+			return nil
+		}
+		if _, err := dst.write(func() ([]byte, error) { return p, nil }); err != nil {
+			return err
+		}
+		imported += pending
+		p, pending = p[:0], 0
+		return nil
+	}
+	r := bufio.NewReader(src)
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return imported, err
+		}
+		if len(bytes.TrimSpace(b)) > 0 {
+			entry, perr := dst.prepare(b)
+			if perr != nil {
+				if batch >= 0 {
+					if err := flush(); err != nil {
+						return imported, err
+					}
+				}
+				return imported, fmt.Errorf("jsonl: line %d of %s: %w", line, srcPath, perr)
+			}
+			p = append(p, entry...)
+			pending++
+			if batch >= 0 && len(p) >= batch {
+				if err := flush(); err != nil {
+					return imported, err
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return imported, flush()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package jsonl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportFile(t *testing.T) {
+	dir := t.TempDir()
+	clean := filepath.Join(dir, "clean.jsonl")
+	if err := os.WriteFile(clean, []byte("{\"number\": 0}\n\n{\"number\":1}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.jsonl")
+	if err := os.WriteFile(bad, []byte("{\"number\":2}\n{\"number\":3}\n{\"number\":\n{\"number\":5}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := openTemp(t, "import.jsonl")
+	imported, err := ImportFile(store, clean)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 {
+		t.Fatalf("expected (%d) entries imported, got (%d)", 2, imported)
+	}
+	// A strict import of a bad file imports nothing.
+	imported, err = ImportFileStrict(store, bad)
+	if !errors.Is(err, ErrNotJSON) || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expected ErrNotJSON reporting line 3, got (%v)", err)
+	}
+	if imported != 0 {
+		t.Fatalf("expected (%d) entries imported, got (%d)", 0, imported)
+	}
+	// Otherwise the entries before the bad line are imported.
+	imported, err = ImportFile(store, bad)
+	if !errors.Is(err, ErrNotJSON) || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expected ErrNotJSON reporting line 3, got (%v)", err)
+	}
+	if imported != 2 {
+		t.Fatalf("expected (%d) entries imported, got (%d)", 2, imported)
+	}
+	got, err := collect(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"number":0},{"number":1},{"number":2},{"number":3}`
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got wrong entries after import. Expected (%s), got (%s)", want, strings.Join(got, ","))
+	}
+}
@@ -8,7 +8,6 @@ The Read() method returns the last non-corrupt JSON entry.
 Thus different types should be written to their own *Jsonl{}.
 
 *Jsonl{} is safe for concurrent access.
-
 */
 package jsonl
 
@@ -20,188 +19,1325 @@ import (
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 	"unicode/utf8"
 )
 
 const entrySizeCap int64 = 1024 * 1024 * 16 // 16M
+const chunkSize int64 = 4096                // 4K
+
 // ErrNotJSON is returned if the argument passed to Write() was
 // not valid JSON.
 var ErrNotJSON = fmt.Errorf("argument to Write() was not valid JSON")
 
+// ErrEmptyWrite is returned if the argument passed to Write() was
+// empty or only whitespace, distinguishing nothing to write from
+// malformed JSON. It wraps ErrNotJSON.
+var ErrEmptyWrite = fmt.Errorf("%w: argument to Write() was empty", ErrNotJSON)
+
+// ErrEntryTooLarge is returned if an entry exceeds the maximum
+// entry size.
+var ErrEntryTooLarge = fmt.Errorf("jsonl: entry exceeds the size limit")
+
+// ErrDiskFull is returned by Write() if the disk is full.
+var ErrDiskFull = fmt.Errorf("jsonl: disk full")
+
+// ErrShortWrite is returned by Write() if the underlying file
+// accepted only part of an entry without returning an error. It
+// wraps io.ErrShortWrite.
+var ErrShortWrite = fmt.Errorf("jsonl: short write: %w", io.ErrShortWrite)
+
+// ErrEmpty is returned by ReadBytes() and Latest() when the
+// store does not contain any entries.
+var ErrEmpty = fmt.Errorf("jsonl: store is empty")
+
+// ErrNoValidEntry is returned when reading the latest entry of a
+// file which holds data, but no non-corrupt entries. This tells a
+// wholly corrupt store from an empty one.
+var ErrNoValidEntry = fmt.Errorf("jsonl: no valid entry in a non-empty file")
+
+// ErrMirror is returned by Write() if an entry was written to the
+// store but writing it to the WithMirror() store failed.
+var ErrMirror = fmt.Errorf("jsonl: failed to write to the mirror")
+
+// ErrSyncTimeout is returned by Write() if syncing the file takes
+// longer than the WithSyncTimeout() duration. The entry has been
+// written, but may not be durable.
+var ErrSyncTimeout = fmt.Errorf("jsonl: sync timed out")
+
+// ErrCloseTimeout is returned by Close() if its final sync or
+// compaction takes longer than the WithCloseTimeout() duration. The
+// file has been released regardless.
+var ErrCloseTimeout = fmt.Errorf("jsonl: close timed out")
+
+// ErrBackpressure is returned by Write() if opened WithBackpressure()
+// and a subscriber didn't catch up within the timeout. The entry has
+// not been written.
+var ErrBackpressure = fmt.Errorf("jsonl: timed out waiting for a subscriber to catch up")
+
+// ErrPanic is returned when a function passed to the store, such as
+// a hook, panics. The panic is recovered so that the store remains
+// usable.
+var ErrPanic = fmt.Errorf("jsonl: callback panicked")
+
+// call calls fn, returning a recovered panic as an error wrapping
+// ErrPanic.
+func call(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrPanic, r)
+		}
+	}()
+	return fn()
+}
+
+// OpError is returned when an operation on the store fails, naming
+// the operation, such as "Read" or "Compact", and wrapping the error
+// which failed it, such as an *os.PathError.
+type OpError struct {
+	Op  string
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return "jsonl: " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// opError wraps err in an *OpError for op, unless it is nil or
+// already wraps one.
+func opError(op string, err error) error {
+	var opErr *OpError
+	if err == nil || errors.As(err, &opErr) {
+		return err
+	}
+	return &OpError{Op: op, Err: err}
+}
+
 // Open a file as jsonl. The returned jsonl struct implements
 // io.ReadWriteCloser, thus Close() should be called when the
 // data store is no longer needed.
 //
+// If f is not seekable, such as a pipe, its contents are read into
+// memory as with OpenReader().
+//
 // Concurrent Read()s and Write()s are not supported as to
 // prevent data access race conditions.
-func Open(f *os.File) (*Jsonl, error) {
+func Open(f File, opts ...Option) (*Jsonl, error) {
 	if f == nil {
 		return nil, os.ErrNotExist
 	}
-	return &Jsonl{
-		f:  f,
-		mu: &sync.Mutex{},
-	}, nil
+	osf, ok := f.(*os.File)
+	if ok && osf == nil {
+		return nil, os.ErrNotExist
+	}
+	if s, ok := f.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekCurrent); err != nil {
+			if r, ok := f.(io.Reader); ok {
+				return OpenReader(r, opts...)
+			}
+		}
+	}
+	j, err := open(f, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		j.name = osf.Name()
+	}
+	if j.cfg.sidecar {
+		if j.cfg.newestFirst {
+			return nil, fmt.Errorf("jsonl: a sidecar of a newest-first store: %w", errors.ErrUnsupported)
+		}
+		if j.name == "" {
+			return nil, fmt.Errorf("jsonl: a sidecar requires a named file: %w", errors.ErrUnsupported)
+		}
+		var metaOpts []Option
+		if j.cfg.truncateOnOpen {
+			metaOpts = append(metaOpts, WithTruncateOnOpen())
+		}
+		if j.meta, err = OpenFile(sidecarName(j.name), metaOpts...); err != nil {
+			return nil, fmt.Errorf("jsonl failed to open the sidecar: %w", err)
+		}
+	}
+	return j, nil
+}
+
+// open returns a *Jsonl backed by f with opts applied.
+func open(f File, opts ...Option) (*Jsonl, error) {
+	j := &Jsonl{
+		f:       f,
+		mu:      &sync.RWMutex{},
+		changed: make(chan struct{}),
+		count:   -1,
+	}
+	for _, opt := range opts {
+		if err := opt(&j.cfg); err != nil {
+			return nil, err
+		}
+	}
+	if j.cfg.mmap {
+		// Reads made without the lock, such as by All(), may still be
+		// reading a mapping when the store truncates the file, and a
+		// read of a mapping past the end of the file faults.
+		if j.cfg.truncateGarbage || j.cfg.readRepair {
+			return nil, fmt.Errorf("jsonl: truncating a partial write of a mapped file: %w", errors.ErrUnsupported)
+		}
+		j.mm = &mmapState{}
+	}
+	if err := j.formatHeader(); err != nil {
+		return nil, err
+	}
+	stat, err := j.stat("open")
+	if err != nil {
+		return nil, err
+	}
+	j.seen.Store(stat.Size())
+	j.durable.Store(stat.Size())
+	if j.cfg.validateOnOpen {
+		if err := j.verify("open"); err != nil {
+			return nil, err
+		}
+	}
+	if j.cfg.onCorruptTail != nil {
+		view, err := j.view("open")
+		if err != nil {
+			return nil, err
+		}
+		if err := j.checkTail(view); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
 }
 
 // OpenFile is a convenience method for opening a jsonl file
-func OpenFile(filename string) (*Jsonl, error) {
-	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
+func OpenFile(filename string, opts ...Option) (*Jsonl, error) {
+	return openFile(filename, 0, opts...)
+}
+
+// CreateFile creates a new jsonl file, returning an error wrapping
+// fs.ErrExist if the file already exists. Unlike OpenFile(), it never
+// opens an existing store, for initialization which must not reuse
+// one.
+func CreateFile(filename string, opts ...Option) (*Jsonl, error) {
+	return openFile(filename, os.O_EXCL, opts...)
+}
+
+// RecoveryReport describes the healing performed by
+// OpenFileWithReport().
+type RecoveryReport struct {
+	// TruncatedBytes is the length of the partial write truncated.
+	TruncatedBytes int64
+	// CorruptTail reports whether the file ended in a partial write.
+	CorruptTail bool
+	// EntriesBefore and EntriesAfter are the number of entries before
+	// and after healing.
+	EntriesBefore, EntriesAfter int
+}
+
+// OpenFileWithReport opens a jsonl file as OpenFile() does, and
+// reports what was healed. If the file ends in a partial write and
+// WithTruncateTrailingGarbage() or WithReadRepair() is given, the
+// partial write is truncated.
+func OpenFileWithReport(filename string, opts ...Option) (*Jsonl, RecoveryReport, error) {
+	var report RecoveryReport
+	j, err := OpenFile(filename, opts...)
+	if err != nil {
+		return nil, report, err
+	}
+	err = func() error {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		// Lock the file so that the tail found is the one truncated.
+		unlock, err := lockFile(j.f)
+		if err != nil {
+			return fmt.Errorf("jsonl failed to lock the file: %w", err)
+		}
+		defer unlock()
+		if report.EntriesBefore, err = j.entryCount("OpenFileWithReport"); err != nil {
+			return err
+		}
+		view, err := j.view("OpenFileWithReport")
+		if err != nil {
+			return err
+		}
+		tail, err := view.tail()
+		if err != nil {
+			return err
+		}
+		report.CorruptTail = tail < view.size
+		if report.CorruptTail && (j.cfg.truncateGarbage || j.cfg.readRepair) {
+			truncated, err := j.truncateTail("OpenFileWithReport", view.size)
+			if err != nil {
+				return err
+			}
+			report.TruncatedBytes = view.size - truncated
+		}
+		report.EntriesAfter, err = j.entryCount("OpenFileWithReport")
+		return err
+	}()
+	if err != nil {
+		_ = j.Close()
+		return nil, RecoveryReport{}, err
+	}
+	return j, report, nil
+}
+
+// openFile opens filename with flag added to the flags implied by
+// opts.
+func openFile(filename string, flag int, opts ...Option) (*Jsonl, error) {
+	var cfg config
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(filename, cfg.openFlag()|flag, 0o600)
 	if err != nil {
 		return nil, err
 	}
-	j, err := Open(f)
+	if cfg.truncateOnOpen {
+		hadHeader, err := truncateOnOpen(f, cfg.headerBytes)
+		if err != nil {
+			_ = f.Close()
+			return nil, &OpError{Op: "truncate on open", Err: err}
+		}
+		if hadHeader {
+			// The emptied file is given a fresh format header.
+			opts = append(opts[:len(opts):len(opts)], WithHeader())
+		}
+	}
+	j, err := Open(f, opts...)
 	if err != nil {
+		_ = f.Close()
+		if flag&os.O_EXCL != 0 {
+			// Don't leave behind a file which would make a retry fail.
+			_ = os.Remove(filename)
+		}
 		return nil, err
 	}
-	j.f = f
+	j.f = j.wrapDirect(f)
 	return j, nil
 }
 
+// truncateOnOpen truncates f to the header of size headerBytes, if
+// it's any longer, under the file lock so as not to tear a write by
+// another handle. It reports whether a format header followed the
+// header, which is truncated with the entries to be written afresh.
+func truncateOnOpen(f *os.File, headerBytes int64) (bool, error) {
+	unlock, err := lockFile(f)
+	if err != nil {
+		return false, fmt.Errorf("jsonl failed to lock the file: %w", err)
+	}
+	defer unlock()
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if stat.Size() <= headerBytes {
+		return false, nil
+	}
+	line := make([]byte, chunkSize)
+	n, err := f.ReadAt(line, headerBytes)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("jsonl failed to read the format header: %w", err)
+	}
+	_, _, hadHeader := parseHeader(line[:n])
+	if err := f.Truncate(headerBytes); err != nil {
+		return false, fmt.Errorf("jsonl failed to truncate the file on open: %w", err)
+	}
+	return hadHeader, f.Sync()
+}
+
 var _ io.ReadWriteCloser = &Jsonl{}
 
 // Jsonl is a mutex-protect jsonl file which implements io.ReadWriteCloser.
 type Jsonl struct {
-	f  *os.File
-	mu *sync.Mutex
+	f File
+	// name is the path of the file, if known. It is required to
+	// rewrite the file.
+	name string
+	mu   *sync.RWMutex
+	cfg  config
+	// changed is closed and replaced whenever the file changes.
+	changed chan struct{}
+	// generation counts the rewrites of the file, such as by
+	// Compact(), after which offsets into the previous file are
+	// meaningless, and rewritten is the size of the file as rewritten,
+	// from which watchers resume.
+	generation uint64
+	rewritten  int64
+	// appended counts the entries appended through j. Every write to
+	// a newest-first store rewrites it, so its watchers find the
+	// entries they have yet to receive by this count rather than by
+	// offset.
+	appended int
+	// count is the number of entries in the file when it was
+	// countSize bytes long, or -1 if they have not been counted. See
+	// entryCount().
+	count     int
+	countSize int64
+	// mm holds the mappings of the file if opened WithMmap().
+	mm *mmapState
+	// meta is the sidecar holding entry metadata if opened
+	// WithSidecar().
+	meta *Jsonl
+	// closed is set by Close().
+	closed bool
+	// scratch is a buffer in which Write() prepares entries, kept
+	// for reuse between writes.
+	scratchMu sync.Mutex
+	scratch   []byte
+	// async tracks the CompactAsync() compactions which Close() waits
+	// for.
+	async sync.WaitGroup
+	// seen is the size of the file when this handle last wrote to it
+	// or read its latest entry. See Consistent().
+	seen atomic.Int64
+	// linesErr is the error which ended the last iteration of Lines().
+	linesErr error
+	// durable is the size of the file when this handle last synced
+	// it. See DurableOffset().
+	durable atomic.Int64
+	// fileSeq numbers the file currently held, and is incremented
+	// whenever it's released, such as when a rewrite replaces it.
+	// readers counts the reads in progress without j.mu held of each
+	// file by number, and retired holds the funcs closing released
+	// files which are still being read. See acquire().
+	fileSeq uint64
+	refMu   sync.Mutex
+	readers map[uint64]int
+	retired map[uint64]func() error
+	// subs are the subscribers paced WithBackpressure(), and progress
+	// is closed and replaced whenever one of them receives an entry or
+	// leaves. Both are guarded by subMu.
+	subMu    sync.Mutex
+	subs     map[*subscriber]struct{}
+	progress chan struct{}
+}
+
+// File returns the *os.File backing the store, for advanced uses such
+// as platform-specific syscalls. It returns nil if the store is not
+// backed by an *os.File, or has been closed. Reading or writing the
+// file directly bypasses the store's locking and validation and can
+// corrupt it, and the file may be replaced by Compact() and other
+// rewrites, so it should not be retained.
+func (j *Jsonl) File() *os.File {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.closed {
+		return nil
+	}
+	switch f := j.f.(type) {
+	case *os.File:
+		return f
+	case *directFile:
+		return f.File
+	case *lazyFile:
+		if osf, err := f.file(); err == nil {
+			return osf
+		}
+	}
+	return nil
 }
 
-// Close the jsonl file.
+// Close the jsonl file, after waiting for any CompactAsync()
+// compactions to finish.
 func (j *Jsonl) Close() error {
-	return j.f.Close()
+	j.async.Wait()
+	j.mu.Lock()
+	j.closed = true
+	if l, ok := j.f.(*lazyFile); ok && !l.opened() {
+		// Nothing to compact or release.
+		defer j.mu.Unlock()
+		return l.Close()
+	}
+	timeout := j.cfg.closeTimeout
+	if timeout <= 0 {
+		defer j.mu.Unlock()
+		return j.close()
+	}
+	// The lock is released by close() once it finishes, so that if it
+	// is abandoned the store remains locked until the hung sync or
+	// compaction returns, rather than being used concurrently.
+	f := j.f
+	done := make(chan error, 1)
+	go func() {
+		defer j.mu.Unlock()
+		done <- j.close()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// Closing the file fails the hung sync or compaction, if the
+		// backend allows, and releases the descriptor either way.
+		return errors.Join(fmt.Errorf("%w after %s", ErrCloseTimeout, timeout), f.Close())
+	}
 }
 
-func (j *Jsonl) Decode(v interface{}) error {
-	if j.f == nil {
-		return os.ErrNotExist
+// close runs the final sync and compaction of Close(), then releases
+// the file. j.mu must be held for writing.
+func (j *Jsonl) close() error {
+	var err error
+	if j.cfg.compactOnClose {
+		err = j.compact("Close")
 	}
-	stat, err := j.f.Stat()
+	if j.cfg.closeTimeout > 0 {
+		err = errors.Join(err, j.syncFile())
+	}
+	f, mm := j.f, j.mm
+	err = errors.Join(err, j.retire(func() error {
+		err := f.Close()
+		if mm != nil {
+			err = errors.Join(err, mm.close())
+		}
+		return err
+	}))
+	if j.meta != nil {
+		err = errors.Join(err, j.meta.Close())
+	}
+	return err
+}
+
+func (j *Jsonl) Decode(v interface{}) error {
+	view, release, err := j.readView("Decode")
 	if err != nil {
 		return err
 	}
-	if stat.Size() == 0 {
+	defer release()
+	if view.size <= view.start {
 		// Empty file, nothing to decode.
 		return nil
 	}
-	dec := json.NewDecoder(j)
-	return dec.Decode(v)
+	if err := j.checkTail(view); err != nil {
+		return err
+	}
+	j.seen.Store(view.size)
+	// Decode straight from the scan buffer rather than from a copy
+	// of the entry, which for large entries would double the memory
+	// used. A json.Decoder over an io.SectionReader of the entry
+	// avoids the copy too, but grows its own buffer as it reads.
+	return view.latest(func(entry []byte) error {
+		return json.Unmarshal(entry, v)
+	})
 }
 
-func (j *Jsonl) Encode(v interface{}) error {
-	if j.f == nil {
-		return os.ErrNotExist
+// DecodeOldest decodes the oldest non-corrupt entry into v, for
+// replaying a store from its start. It returns io.EOF if the store
+// does not contain any entries.
+func (j *Jsonl) DecodeOldest(v any) error {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	scan := j.entries
+	if j.cfg.newestFirst {
+		scan = j.entriesReverse
+	}
+	found := false
+	err := scan("DecodeOldest", func(_ int64, entry []byte) error {
+		found = true
+		if err := json.Unmarshal(entry, v); err != nil {
+			return err
+		}
+		return errStop
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return io.EOF
+	}
+	return nil
+}
+
+// DecodeIfPresent decodes the latest entry into v, reporting
+// whether the store held an entry to decode. Unlike Decode(), this
+// lets callers tell an empty store, where v is left untouched, from
+// a decoded entry.
+func (j *Jsonl) DecodeIfPresent(v interface{}) (bool, error) {
+	entry, err := j.ReadBytes()
+	if errors.Is(err, ErrEmpty) {
+		return false, nil
 	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(entry, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (j *Jsonl) Encode(v interface{}) error {
 	enc := json.NewEncoder(j)
 	return enc.Encode(v)
 }
 
 // Read the latest non-corrupt jsonl entry into p.
 func (j *Jsonl) Read(p []byte) (int, error) {
-	const chunkSize int64 = 4096 // 4K
-	if j.f == nil {
+	entry, err := j.latest("Read")
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, entry), nil
+}
+
+// ReadBytes returns a copy of the latest non-corrupt jsonl entry,
+// ErrEmpty if the store does not contain any entries, or
+// ErrNoValidEntry if it holds only corrupt data.
+func (j *Jsonl) ReadBytes() ([]byte, error) {
+	entry, err := j.latest("ReadBytes")
+	if errors.Is(err, io.EOF) {
+		return nil, ErrEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// LatestWithVersion returns a copy of the latest non-corrupt entry
+// along with its zero-based version, the number of non-corrupt
+// entries before it, as read together under one lock. This suits
+// optimistic updates which must know which version they read. It
+// returns ErrEmpty if the store does not contain any entries, or
+// ErrNoValidEntry if it holds only corrupt data.
+func (j *Jsonl) LatestWithVersion() ([]byte, int, error) {
+	// The lock is held for writing as entryCount() caches the count.
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, err := j.readLatest("LatestWithVersion")
+	if errors.Is(err, io.EOF) {
+		return nil, 0, ErrEmpty
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := j.entryCount("LatestWithVersion")
+	if err != nil {
+		return nil, 0, err
+	}
+	return entry, count - 1, nil
+}
+
+// ReadIntoPooled calls fn with the latest non-corrupt jsonl entry,
+// or returns ErrEmpty if the store does not contain any entries. The
+// entry is read into a pooled buffer, avoiding the allocation made
+// by ReadBytes(), so fn must not retain the slice after it returns.
+// An error returned by fn is returned.
+func (j *Jsonl) ReadIntoPooled(fn func(entry []byte) error) error {
+	view, release, err := j.snapshot("ReadIntoPooled")
+	if err != nil {
+		return err
+	}
+	defer release()
+	err = view.latest(func(entry []byte) error {
+		return call(func() error { return fn(entry) })
+	})
+	if errors.Is(err, io.EOF) {
+		return ErrEmpty
+	}
+	return err
+}
+
+// Latest returns the latest non-corrupt jsonl entry as a
+// json.RawMessage, or ErrEmpty if the store does not contain any
+// entries. It is useful for forwarding an entry without knowing
+// its shape.
+func (j *Jsonl) Latest() (json.RawMessage, error) {
+	return j.ReadBytes()
+}
+
+// latest returns a copy of the latest entry as readLatest() does,
+// then truncates a partial write from the end of the file if opened
+// WithReadRepair().
+func (j *Jsonl) latest(op string) ([]byte, error) {
+	view, release, err := j.readView(op)
+	var entry []byte
+	if err == nil {
+		entry, err = j.latestIn(view)
+		release()
+	}
+	if j.cfg.readRepair && (err == nil || errors.Is(err, io.EOF) || errors.Is(err, ErrNoValidEntry)) {
+		if err := j.repairTail(); err != nil {
+			return nil, err
+		}
+	}
+	return entry, err
+}
+
+// repairTail truncates a partial write from the end of the file.
+func (j *Jsonl) repairTail() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	unlock, err := lockFile(j.f)
+	if err != nil {
+		return fmt.Errorf("jsonl failed to lock the file: %w", err)
+	}
+	defer unlock()
+	stat, err := j.stat("read repair")
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+	if size <= j.cfg.headerBytes {
+		return nil
+	}
+	lr := make([]byte, 1)
+	if _, err := j.f.ReadAt(lr, size-1); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("jsonl failed to read the last byte of file: %w", err)
+	}
+	if lr[0] == '\n' {
+		return nil
+	}
+	_, err = j.truncateTail("read repair", size)
+	return err
+}
+
+// readLatest returns a copy of the latest entry found by scanning
+// the file backwards, or io.EOF if there is none, or ErrNoValidEntry
+// if the file holds only corrupt data. The caller must hold j.mu.
+func (j *Jsonl) readLatest(op string) ([]byte, error) {
+	view, err := j.view(op)
+	if err != nil {
+		return nil, err
+	}
+	return j.latestIn(view)
+}
+
+// readStored is readLatest without WithReadTransform(), for writes
+// which derive the next entry from the latest one: what the transform
+// hides or migrates must not be lost when the result is written back.
+// The caller must hold j.mu.
+func (j *Jsonl) readStored(op string) ([]byte, error) {
+	view, err := j.view(op)
+	if err != nil {
+		return nil, err
+	}
+	return j.latestIn(view.stored())
+}
+
+// latestIn returns a copy of the latest entry in view.
+func (j *Jsonl) latestIn(view view) ([]byte, error) {
+	if err := j.checkTail(view); err != nil {
+		return nil, err
+	}
+	j.seen.Store(view.size)
+	var latest []byte
+	err := view.latest(func(entry []byte) error {
+		latest = append([]byte(nil), entry...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return latest, nil
+}
+
+// checkTail calls the WithOnCorruptTail() callback, if any, with the
+// partial write at the end of view, returning its error.
+func (j *Jsonl) checkTail(view view) error {
+	if j.cfg.onCorruptTail == nil {
+		return nil
+	}
+	tail, err := view.tail()
+	if err != nil || tail >= view.size {
+		return err
+	}
+	b := make([]byte, min(view.size-tail, view.limit))
+	if _, err := view.f.ReadAt(b, tail); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("jsonl failed to read the corrupt tail: %w", err)
+	}
+	return call(func() error { return j.cfg.onCorruptTail(b) })
+}
+
+// SetMaxEntrySize sets the maximum size of entries read or written
+// by subsequent calls, replacing the 16M default or the limit set by
+// WithMaxMemory(). It lets long-lived processes raise the limit after
+// detecting larger payloads.
+func (j *Jsonl) SetMaxEntrySize(n int64) error {
+	if n <= 0 {
+		return fmt.Errorf("jsonl: max entry size must be positive")
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cfg.maxEntry = n
+	return nil
+}
+
+// Write the JSON byte slice p to the jsonl file. Any JSON value is
+// an entry, including a bare null, boolean, number or string, unless
+// opened WithRejectNull().
+func (j *Jsonl) Write(p []byte) (n int, err error) {
+	j.mu.RLock()
+	missing, limit := j.f == nil, j.cfg.maxEntrySize()
+	j.mu.RUnlock()
+	if missing {
 		return 0, os.ErrNotExist
 	}
-	stat, err := j.f.Stat()
+	// The entry is prepared in a reused buffer, which is safe as it's
+	// not retained once written.
+	scratch := j.getScratch()
+	p, err = j.prepareInto(scratch, p, limit)
+	if err == nil {
+		n, err = j.write(func() ([]byte, error) { return p, nil })
+	}
+	if cap(p) > cap(scratch) {
+		// Keep the buffer as it grew.
+		scratch = p
+	}
+	j.putScratch(scratch)
+	return n, err
+}
+
+// getScratch takes the buffer kept by Write() for reuse, or allocates
+// one if it's in use by a concurrent write.
+func (j *Jsonl) getScratch() []byte {
+	j.scratchMu.Lock()
+	b := j.scratch
+	j.scratch = nil
+	j.scratchMu.Unlock()
+	if b == nil {
+		b = make([]byte, 0, j.cfg.scratchBufferSize())
+	}
+	return b
+}
+
+// putScratch keeps b for reuse by Write(), unless it has grown too
+// large to keep or a larger buffer is already kept.
+func (j *Jsonl) putScratch(b []byte) {
+	if cap(b) > max(j.cfg.scratchBufferSize(), maxPooledBuf) {
+		return
+	}
+	j.scratchMu.Lock()
+	if cap(b) > cap(j.scratch) {
+		j.scratch = b[:0]
+	}
+	j.scratchMu.Unlock()
+}
+
+// prepare validates and compacts the JSON entry p for writing,
+// returning it with a trailing newline.
+func (j *Jsonl) prepare(p []byte) ([]byte, error) {
+	j.mu.RLock()
+	limit := j.cfg.maxEntrySize()
+	j.mu.RUnlock()
+	return j.prepareLimit(p, limit)
+}
+
+// prepareLimit implements prepare() with the maximum entry size
+// limit, for callers already holding j.mu.
+func (j *Jsonl) prepareLimit(p []byte, limit int64) ([]byte, error) {
+	return j.prepareInto(nil, p, limit)
+}
+
+// prepareInto implements prepareLimit(), preparing the entry in the
+// buffer scratch if it has room.
+func (j *Jsonl) prepareInto(scratch []byte, p []byte, limit int64) ([]byte, error) {
+	if int64(len(p)) > limit {
+		return nil, fmt.Errorf("%w: data passed to write exceeds %d bytes", ErrEntryTooLarge, limit)
+	}
+	if err := ValidateEntry(p); err != nil {
+		return nil, err
+	}
+	p, err := j.transform(p)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	buf := make([]byte, chunkSize, entrySizeCap)
-	// than this, you should probably be using a database.
-	start := -1
-	end := -1
-	for off := stat.Size() / chunkSize; off >= 0; off = off - chunkSize {
-		n, err := j.f.ReadAt(buf, off*chunkSize)
-		if err != nil {
-			if !errors.Is(err, io.EOF) {
-				return 0, fmt.Errorf("jsonl failed reading the underlying file: %w", err)
-			}
+	p = bytes.TrimSpace(p)
+	buf := bytes.NewBuffer(scratch[:0])
+	// Leave room for the newline and any alignment padding.
+	buf.Grow(len(p) + 1 + j.cfg.alignment)
+	if err := json.Compact(buf, p); err != nil {
+		return nil, ErrNotJSON
+	}
+	p = buf.Bytes()
+	if int64(len(p)) > limit {
+		return nil, fmt.Errorf("%w: transformed entry exceeds %d bytes", ErrEntryTooLarge, limit)
+	}
+	if err := j.checkContainer(p); err != nil {
+		return nil, err
+	}
+	// Append single newline at the end of the buf
+	if p[len(p)-1] != '\n' {
+		p = append(p, '\n')
+	}
+	return j.align(p), nil
+}
+
+// align pads the entry p, which ends in a newline, with spaces
+// before the newline so that its length is a multiple of the
+// WithWriteAlignment() size, if any.
+func (j *Jsonl) align(p []byte) []byte {
+	n := j.cfg.alignment
+	if n <= 1 || len(p)%n == 0 {
+		return p
+	}
+	pad := n - len(p)%n
+	p = append(p[:len(p)-1], bytes.Repeat([]byte(" "), pad)...)
+	return append(p, '\n')
+}
+
+// WriteLines appends buf, which holds newline-delimited JSON entries
+// such as the output of another jsonl tool, in a single write and
+// sync. Blank lines are skipped. If any line is not valid JSON,
+// nothing is written and an error wrapping ErrNotJSON reports the
+// line number. It returns the number of bytes written.
+func (j *Jsonl) WriteLines(buf []byte) (int, error) {
+	j.mu.RLock()
+	missing, limit := j.f == nil, j.cfg.maxEntrySize()
+	j.mu.RUnlock()
+	if missing {
+		return 0, os.ErrNotExist
+	}
+	var p []byte
+	for i, line := range bytes.Split(buf, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if int64(len(line)) > limit {
+			return 0, fmt.Errorf("%w: line %d exceeds %d bytes", ErrEntryTooLarge, i+1, limit)
 		}
-		if n == 0 {
-			return 0, nil
+		if !validJSON(line) {
+			return 0, fmt.Errorf("%w: line %d", ErrNotJSON, i+1)
 		}
-		// Regardless of what chunk we're processing, we read backwards over the
-		// whole buf. While this is somewhat inefficient, in-memory manipulations
-		// are fast.
-		for i := len(buf) - 1; i >= 0; i-- {
-			if buf[i] == '\n' {
-				end = start
-				start = i
+		if j.cfg.writeTransform != nil {
+			t, err := j.transform(line)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %w", i+1, err)
 			}
-			if end > 0 {
-				break
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, bytes.TrimSpace(t)); err != nil {
+				return 0, ErrNotJSON
+			}
+			if line = buf.Bytes(); int64(len(line)) > limit {
+				return 0, fmt.Errorf("%w: transformed line %d exceeds %d bytes", ErrEntryTooLarge, i+1, limit)
 			}
 		}
-		if end > 0 {
-			break
+		if err := j.checkContainer(line); err != nil {
+			return 0, fmt.Errorf("line %d: %w", i+1, err)
 		}
-		buf = buf[:n] // will over-allocate in some circumstances, but that's fine.
+		p = append(p, j.align(append(line[:len(line):len(line)], '\n'))...)
+	}
+	if len(p) == 0 {
+		return 0, nil
 	}
-	// Handle the first entry not having a newline
-	if end < 0 && start > -1 {
-		if stat.Size()/chunkSize == 0 {
-			end = start
-			start = 0
-		} else {
-			return 0, fmt.Errorf("jsonl: entry exceeded 16M size limit")
+	return j.write(func() ([]byte, error) { return p, nil })
+}
+
+// WriteBatch appends entries in a single write with one sync of the
+// file, rather than a sync per entry as Write() does, so the whole
+// batch shares one durability barrier. Each entry is compacted as by
+// Write(). If any entry is invalid nothing is written and the error
+// reports its index. It returns the number of bytes written.
+func (j *Jsonl) WriteBatch(entries [][]byte) (int, error) {
+	var p []byte
+	for i, entry := range entries {
+		b, err := j.prepare(entry)
+		if err != nil {
+			return 0, fmt.Errorf("jsonl: entry %d of batch: %w", i, err)
 		}
+		p = append(p, b...)
 	}
-	if end < 0 || start < 0 {
-		return 0, io.EOF
+	if len(p) == 0 {
+		return 0, nil
 	}
-	return copy(p, buf[start:end]), nil
+	return j.write(func() ([]byte, error) { return p, nil })
 }
 
-// Write the JSON byte slice p to the jsonl file.
-func (j *Jsonl) Write(p []byte) (n int, err error) {
+// AppendJSONValues appends each of vals as its own entry with a
+// single write and sync, as WriteBatch() does, for callers assembling
+// raw JSON values of mixed types.
+func (j *Jsonl) AppendJSONValues(vals ...json.RawMessage) (int, error) {
+	entries := make([][]byte, len(vals))
+	for i, v := range vals {
+		entries[i] = v
+	}
+	return j.WriteBatch(entries)
+}
+
+// transform applies the WithWriteTransform() function, if any, to
+// the valid JSON p, checking that the result is valid JSON too.
+func (j *Jsonl) transform(p []byte) ([]byte, error) {
+	if j.cfg.writeTransform == nil {
+		return p, nil
+	}
+	var t []byte
+	err := call(func() (err error) {
+		t, err = j.cfg.writeTransform(p)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("jsonl write transform failed: %w", err)
+	}
+	if !validJSON(t) {
+		return nil, fmt.Errorf("%w: write transform returned %q", ErrNotJSON, t)
+	}
+	return t, nil
+}
+
+// readTransform applies the WithReadTransform() function, if any, to
+// entry.
+func (j *Jsonl) readTransform(entry []byte) ([]byte, error) {
+	if j.cfg.readTransform == nil {
+		return entry, nil
+	}
+	var t []byte
+	err := call(func() (err error) {
+		t, err = j.cfg.readTransform(entry)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("jsonl read transform failed: %w", err)
+	}
+	return t, nil
+}
+
+// ValidateEntry reports whether p would pass the checks Write() makes
+// on its argument, returning ErrEmptyWrite if p is empty, ErrNotJSON
+// if p is not valid UTF-8 encoded JSON, and nil otherwise. It does
+// not consider the size limit or write transform of any store.
+func ValidateEntry(p []byte) error {
+	if len(bytes.TrimSpace(p)) == 0 {
+		return ErrEmptyWrite
+	}
+	if !validJSON(p) {
+		return ErrNotJSON
+	}
+	return nil
+}
+
+// checkContainer returns an error wrapping ErrNotJSON if opened
+// WithRejectNull() and the entry p, which is valid JSON without
+// leading whitespace, is not an object or array.
+func (j *Jsonl) checkContainer(p []byte) error {
+	if j.cfg.rejectNull && p[0] != '{' && p[0] != '[' {
+		return fmt.Errorf("%w: entry is not an object or array", ErrNotJSON)
+	}
+	return nil
+}
+
+// validJSON reports whether p is valid UTF-8 encoded JSON.
+func validJSON(p []byte) bool {
+	return utf8.Valid(p) && json.Valid(p)
+}
+
+// write appends the entries returned by build, which must end in a
+// newline, to the file. build is called with j.mu held for writing,
+// so that it may check the current entries, and writes nothing if it
+// returns nil. If the disk is full, the disk full hook is called and
+// the write retried.
+func (j *Jsonl) write(build func() ([]byte, error)) (n int, err error) {
+	if err := j.awaitSubscribers(); err != nil {
+		return 0, err
+	}
+	n, p, version, err := j.appendLocked(build)
+	if errors.Is(err, syscall.ENOSPC) && j.cfg.diskFullHook != nil {
+		// The hook is called without the lock held, as it will likely
+		// compact or rotate the store to free space.
+		if err := call(j.cfg.diskFullHook); err != nil {
+			return n, fmt.Errorf("%w: disk full hook failed: %w", ErrDiskFull, err)
+		}
+		n, p, version, err = j.appendLocked(build)
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return n, fmt.Errorf("%w: %w", ErrDiskFull, err)
+	}
+	if err != nil {
+		return n, err
+	}
+	if j.cfg.metrics != nil {
+		for _, entry := range bytes.SplitAfter(p, []byte("\n")) {
+			if len(entry) == 0 {
+				continue
+			}
+			err := call(func() error {
+				j.cfg.metrics.ObserveWriteSize(len(entry))
+				return nil
+			})
+			if err != nil {
+				return n, fmt.Errorf("jsonl metrics failed after writing: %w", err)
+			}
+		}
+	}
+	if j.cfg.writeHook != nil && len(p) > 0 {
+		// The hook is called without the lock held so that it may use
+		// the store.
+		for _, entry := range bytes.SplitAfter(p[:len(p)-1], []byte("\n")) {
+			entry = bytes.TrimSuffix(entry, []byte("\n"))
+			err := call(func() error {
+				j.cfg.writeHook(entry, version)
+				return nil
+			})
+			if err != nil {
+				return n, fmt.Errorf("jsonl write hook failed after writing: %w", err)
+			}
+			version++
+		}
+	}
+	return n, nil
+}
+
+// appendLocked appends the entries returned by build under j.mu,
+// returning them along with the version of the first if a write hook
+// needs it.
+func (j *Jsonl) appendLocked(build func() ([]byte, error)) (n int, p []byte, version int, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
 	if j.f == nil {
-		return 0, os.ErrNotExist
+		return 0, nil, 0, os.ErrNotExist
+	}
+	if p, err = build(); err != nil || len(p) == 0 {
+		return 0, nil, 0, err
+	}
+	if j.cfg.newestFirst {
+		n, err = j.prepend(p)
+	} else {
+		n, err = j.appendEntry(p)
+	}
+	if err != nil {
+		return n, p, 0, err
 	}
-	if int64(len(p)) > entrySizeCap {
-		return 0, fmt.Errorf("jsonl: data passed to write exceeds the 16M entry size limit")
+	entries := bytes.Count(p, []byte("\n"))
+	if j.cfg.writeHook != nil {
+		// The entries are counted once written, as the write may
+		// complete a partial write before them as an entry.
+		count, err := j.entryCount("Write")
+		if err != nil {
+			return n, p, 0, err
+		}
+		version = count - entries
 	}
-	// TODO: This function is messy and makes a lot of unnecessary allocations.
-	// My use-cases aren't performance intensive, so this is fine. Ideally I
-	// would write benchmarks and optimize.
-	if !utf8.Valid(p) {
-		return 0, ErrNotJSON
+	j.appended += entries
+	j.published(entries)
+	if j.cfg.mirror != nil {
+		// The mirror is written under the lock so that concurrent
+		// writes reach both stores in the same order.
+		if _, err := j.cfg.mirror.write(func() ([]byte, error) { return p, nil }); err != nil {
+			return n, p, version, fmt.Errorf("%w: %w", ErrMirror, err)
+		}
 	}
-	if !json.Valid(p) {
-		return 0, ErrNotJSON
+	return n, p, version, nil
+}
+
+// entryCount returns the number of entries in the file, counting
+// them on first use and maintaining the count as entries are
+// appended. The count is discarded if the file is changed by another
+// handle. The caller must hold j.mu for writing.
+func (j *Jsonl) entryCount(op string) (int, error) {
+	view, err := j.view(op)
+	if err != nil {
+		return 0, err
 	}
-	var buf bytes.Buffer
-	if err := json.Compact(&buf, bytes.TrimSpace(p)); err != nil {
-		return 0, ErrNotJSON
+	if j.count >= 0 && view.size == j.countSize {
+		return j.count, nil
 	}
-	p = buf.Bytes()
-	// Append single newline at the end of the buf
-	if p[len(p)-1] != '\n' {
-		p = append(p, '\n')
+	count := 0
+	err = view.stored().entries(func(_ int64, _ []byte) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	j.count, j.countSize = count, view.size
+	return count, nil
+}
+
+// stat stats the file, wrapping an error in an *OpError for op.
+func (j *Jsonl) stat(op string) (os.FileInfo, error) {
+	stat, err := j.f.Stat()
+	if err != nil {
+		return nil, &OpError{Op: op, Err: err}
+	}
+	return stat, nil
+}
+
+// truncateTail truncates a partial write from the end of the file,
+// which is size bytes long, if the file supports truncation. It
+// returns the new size of the file. The caller must hold j.mu for
+// writing, and the file lock.
+func (j *Jsonl) truncateTail(op string, size int64) (int64, error) {
+	t, ok := j.f.(interface{ Truncate(int64) error })
+	if !ok {
+		return size, nil
 	}
+	view, err := j.view(op)
+	if err != nil {
+		return size, err
+	}
+	view.size = size
+	tail, err := view.tail()
+	if err != nil {
+		return size, err
+	}
+	// Reads in progress of the truncated tail see a short file, which
+	// they treat as though the partial write were never made.
+	if err := t.Truncate(tail); err != nil {
+		return size, fmt.Errorf("jsonl failed to truncate a partial write: %w", err)
+	}
+	if j.count >= 0 && j.countSize == size {
+		j.countSize = tail
+	}
+	j.seen.CompareAndSwap(size, tail)
+	if j.durable.Load() > tail {
+		j.durable.Store(tail)
+	}
+	return tail, nil
+}
+
+// appendEntry writes p, one or more entries ending in a newline, to
+// the end of the file and syncs it. The file is locked against other
+// handles, which may belong to other processes, for the duration.
+// The caller must hold j.mu for writing.
+func (j *Jsonl) appendEntry(p []byte) (n int, err error) {
+	unlock, err := lockFile(j.f)
+	if err != nil {
+		return 0, fmt.Errorf("jsonl failed to lock the file: %w", err)
+	}
+	defer unlock()
 	// Prior to performing a write, we must check that the last
 	// write completed successfully. If the last character in the
 	// file is not a newline, we must inject one on the next write
-	// to make a valid entry.
-	j.mu.Lock()
-	defer j.mu.Unlock()
-	stat, err := j.f.Stat()
+	// to make a valid entry. The file is stat'd under the lock as
+	// another handle may have written to it since.
+	stat, err := j.stat("Write")
 	if err != nil {
 		return 0, err
 	}
-	lr := make([]byte, 1)
-	n, err = j.f.ReadAt(lr, stat.Size()-1)
-	if n > 0 {
-		if err != nil {
-			if !errors.Is(err, io.EOF) {
-				return 0, fmt.Errorf("jsonl failed to read the last byte of file before Write(): %w", err)
+	entries := bytes.Count(p, []byte("\n"))
+	// injected is set if a newline is injected, which may complete a
+	// partial write as an entry of its own.
+	injected := false
+	size := stat.Size()
+	if size > j.cfg.headerBytes {
+		lr := make([]byte, 1)
+		n, err = j.f.ReadAt(lr, size-1)
+		if n > 0 {
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					return 0, fmt.Errorf("jsonl failed to read the last byte of file before Write(): %w", err)
+				}
+			}
+			if lr[0] != '\n' {
+				truncated := size
+				if j.cfg.truncateGarbage {
+					if truncated, err = j.truncateTail("Write", size); err != nil {
+						return 0, err
+					}
+				}
+				if truncated < size {
+					size = truncated
+				} else {
+					p = append([]byte("\n"), p...)
+					injected = true
+				}
 			}
 		}
-		if lr[0] != '\n' {
-			p = append([]byte("\n"), p...)
-		}
 	}
-	n, err = j.f.Write(p)
+	if j.cfg.noAppend {
+		n, err = j.f.WriteAt(p, size)
+	} else {
+		n, err = j.f.Write(p)
+	}
+	if err == nil && n < len(p) {
+		// The entry is incomplete, and will be demarcated by the newline
+		// injected on the next write.
+		err = fmt.Errorf("%w: wrote %d of %d bytes", ErrShortWrite, n, len(p))
+	}
 	if err != nil {
+		j.count = -1
+		return n, err
+	}
+	if j.count >= 0 && size == j.countSize && !injected {
+		j.count += entries
+		j.countSize = size + int64(n)
+	} else {
+		j.count = -1
+	}
+	j.seen.Store(size + int64(n))
+	if err := j.sync(); err != nil {
 		return n, err
 	}
-	return n, j.f.Sync()
+	j.durable.Store(size + int64(n))
+	if j.meta != nil {
+		count, err := j.entryCount("Write")
+		if err != nil {
+			return n, err
+		}
+		if err := j.writeMeta(size, count-entries, p); err != nil {
+			return n, err
+		}
+	}
+	j.notify()
+	return n, nil
+}
+
+// Sync syncs the file, such as after a write failed with
+// ErrSyncTimeout or another error syncing the entry it had written,
+// and advances DurableOffset() to the end of the file.
+func (j *Jsonl) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	stat, err := j.stat("Sync")
+	if err != nil {
+		return err
+	}
+	if err := j.sync(); err != nil {
+		return err
+	}
+	j.durable.Store(stat.Size())
+	return nil
+}
+
+// DurableOffset returns the offset up to which this handle has synced
+// the file, such as to audit whether any writes are not yet durable
+// by comparing it with the size of the file. It lags the size after a
+// write fails to sync, until Sync() succeeds. The file as it was when
+// opened is assumed durable, and writes by other handles are only
+// counted once this handle next syncs.
+func (j *Jsonl) DurableOffset() int64 {
+	return j.durable.Load()
+}
+
+// sync syncs the file, giving up after the WithSyncTimeout()
+// duration, if any. The caller must hold j.mu for writing.
+func (j *Jsonl) sync() error {
+	timeout := j.cfg.syncTimeout
+	if timeout <= 0 {
+		return j.syncFile()
+	}
+	done := make(chan error, 1)
+	go func() { done <- j.syncFile() }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w after %s", ErrSyncTimeout, timeout)
+	}
+}
+
+// syncFile syncs the file. Backends which don't support syncing,
+// such as some network filesystems, have already accepted the write,
+// so this is logged as a warning rather than failing it.
+func (j *Jsonl) syncFile() error {
+	err := j.f.Sync()
+	if errors.Is(err, errors.ErrUnsupported) || errors.Is(err, syscall.ENOTSUP) {
+		if j.cfg.log != nil {
+			j.cfg.log.Warn("jsonl: sync unsupported, writes may not be durable", "error", err)
+		}
+		return nil
+	}
+	return err
 }
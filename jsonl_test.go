@@ -1,9 +1,15 @@
 package jsonl
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -186,7 +192,7 @@ func TestEmptyDecode(t *testing.T) {
 	go func() {
 		null := &Entry{}
 		if err := store.Decode(null); err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 		ch <- struct{}{}
 	}()
@@ -198,3 +204,760 @@ func TestEmptyDecode(t *testing.T) {
 
 	}
 }
+
+func TestLatest(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(testDir, "latest.jsonl")
+	store, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Latest(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty from Latest() on an empty store, got (%v)", err)
+	}
+	for _, entry := range []string{`{"key":"first"}`, `{"key":"second"}`} {
+		if _, err := store.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest, err := store.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(latest) {
+		t.Fatalf("Latest() returned invalid JSON: %q", latest)
+	}
+	if string(latest) != `{"key":"second"}` {
+		t.Fatalf("got wrong entry from Latest(). Expected (%s), got (%s)", `{"key":"second"}`, latest)
+	}
+}
+
+func TestChunkBoundaryRead(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(testDir, "boundary.jsonl")
+	store, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// 16 entries of 256 bytes each, including the newline, fill
+	// exactly one 4K chunk.
+	var last string
+	for i := 0; i < 16; i++ {
+		entry := fmt.Sprintf(`{"number":%d,"pad":""}`, i)
+		entry = fmt.Sprintf(`{"number":%d,"pad":"%s"}`, i, strings.Repeat("x", 255-len(entry)))
+		if _, err := store.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+		last = entry
+	}
+	stat, err := store.f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != 4096 {
+		t.Fatalf("expected a file size of (%d), got (%d)", 4096, stat.Size())
+	}
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != last {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", last, latest)
+	}
+}
+
+func TestDecodeLargeEntry(t *testing.T) {
+	store := openTemp(t, "large.jsonl")
+	pad := strings.Repeat("x", 64*1024)
+	writeEntries(t, store, `{"pad":"`+pad+`"}`)
+	var v struct {
+		Pad string `json:"pad"`
+	}
+	if err := store.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Pad != pad {
+		t.Fatalf("decoded entry was (%d) bytes, expected (%d)", len(v.Pad), len(pad))
+	}
+}
+
+func TestDecodeMatchesReadBytes(t *testing.T) {
+	store := openTemp(t, "decode.jsonl")
+	writeEntries(t, store, `{"number":0}`, `{"number":1,"name":"one"}`)
+	// Leading whitespace and a partial write must not affect Decode.
+	if _, err := store.f.Write([]byte("  {\"number\":2}\n{\"number\":3")); err != nil {
+		t.Fatal(err)
+	}
+	type entry struct {
+		Number int    `json:"number"`
+		Name   string `json:"name"`
+	}
+	var got, want entry
+	if err := store.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &want); err != nil {
+		t.Fatal(err)
+	}
+	if got != want || got.Number != 2 {
+		t.Fatalf("got wrong entry from Decode(). Expected (%+v), got (%+v)", want, got)
+	}
+}
+
+func TestCallbackPanic(t *testing.T) {
+	panicked := false
+	store := openTemp(t, "panic.jsonl", WithWriteHook(func([]byte, int) {
+		if !panicked {
+			panicked = true
+			panic("hook")
+		}
+	}))
+	if _, err := store.Write([]byte(`{"number":0}`)); !errors.Is(err, ErrPanic) {
+		t.Fatalf("expected ErrPanic from Write(), got (%v)", err)
+	}
+	writeEntries(t, store, `{"number":1}`)
+	err := store.Maintenance(func([]byte) (bool, []byte, error) {
+		panic("maintenance")
+	})
+	if !errors.Is(err, ErrPanic) {
+		t.Fatalf("expected ErrPanic from Maintenance(), got (%v)", err)
+	}
+	// The store must remain usable and unchanged.
+	writeEntries(t, store, `{"number":2}`)
+	if n, err := store.Count(); err != nil || n != 3 {
+		t.Fatalf("expected (3) entries after recovered panics, got (%d, %v)", n, err)
+	}
+}
+
+func TestCreateFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "create.jsonl")
+	store, err := CreateFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":0}`)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateFile(filename); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected fs.ErrExist creating an existing file, got (%v)", err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"number\":0}\n" {
+		t.Fatalf("expected the existing file to be untouched, got (%q)", b)
+	}
+}
+
+func TestDecodeOldest(t *testing.T) {
+	store := openTemp(t, "oldest.jsonl")
+	var v struct {
+		Number int `json:"number"`
+	}
+	if err := store.DecodeOldest(&v); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF from an empty store, got (%v)", err)
+	}
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`, `{"number":2}`)
+	if err := store.DecodeOldest(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Number != 0 {
+		t.Fatalf("got wrong entry from DecodeOldest(). Expected (0), got (%d)", v.Number)
+	}
+	if err := store.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Number != 2 {
+		t.Fatalf("got wrong entry from Decode(). Expected (2), got (%d)", v.Number)
+	}
+}
+
+// FuzzWriteThenRead writes the candidate entries in entries,
+// separated by 0x1e, to a file initially holding contents, checking
+// that writes never disturb earlier entries and that reads only
+// return valid JSON.
+func FuzzWriteThenRead(f *testing.F) {
+	big := `{"pad":"` + strings.Repeat("x", 2*int(chunkSize)) + `"}`
+	f.Add([]byte(""), []byte(""))
+	f.Add([]byte(""), []byte(`{"number":0}`))
+	f.Add([]byte(`{"number":0}`), []byte(`{"number":1}`))
+	f.Add([]byte("{\"number\":0}\n{\"num"), []byte(`{"number":1}`))
+	f.Add([]byte("\n\n  \n"), []byte("{\"number\":0}\x1e \x1e[1, 2]\x1enot json"))
+	f.Add([]byte(big+"\n"), []byte(big+"\x1e"+big[:chunkSize]))
+	// A file holding only a partial write has no valid entry.
+	f.Add([]byte("0"), []byte(""))
+	f.Add([]byte(""), []byte("0"))
+	f.Fuzz(func(t *testing.T, contents, entries []byte) {
+		filename := filepath.Join(t.TempDir(), "fuzz.jsonl")
+		if err := os.WriteFile(filename, contents, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		store, err := OpenFile(filename)
+		if err != nil {
+			// Such as a header of an unsupported version.
+			return
+		}
+		defer store.Close()
+		before, err := store.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, candidate := range bytes.Split(entries, []byte{0x1e}) {
+			_, werr := store.Write(candidate)
+			after, err := store.Entries()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A partial write which is valid JSON becomes an entry
+			// once a write ends it, so entries may be added before
+			// the one written.
+			if len(after) < len(before) {
+				t.Fatalf("write of (%q) removed entries", candidate)
+			}
+			for i := range before {
+				if !bytes.Equal(before[i], after[i]) {
+					t.Fatalf("write of (%q) changed entry (%d) from (%s) to (%s)", candidate, i, before[i], after[i])
+				}
+			}
+			if werr == nil {
+				var want bytes.Buffer
+				if err := json.Compact(&want, candidate); err != nil {
+					t.Fatalf("Write() accepted invalid JSON (%q)", candidate)
+				}
+				if len(after) == len(before) || !bytes.Equal(after[len(after)-1], want.Bytes()) {
+					t.Fatalf("write of (%q) was not the latest entry", candidate)
+				}
+			}
+			before = after
+
+			latest, err := store.ReadBytes()
+			switch {
+			case errors.Is(err, ErrEmpty):
+				if len(after) != 0 {
+					t.Fatal("ReadBytes() returned ErrEmpty from a store with entries")
+				}
+			case errors.Is(err, ErrNoValidEntry):
+				// The file holds only data which isn't an entry.
+				if len(after) != 0 {
+					t.Fatal("ReadBytes() returned ErrNoValidEntry from a store with entries")
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !json.Valid(latest):
+				t.Fatalf("ReadBytes() returned invalid JSON (%q)", latest)
+			}
+		}
+	})
+}
+
+// syncCountingFile is a File which counts its syncs.
+type syncCountingFile struct {
+	*os.File
+	syncs int
+}
+
+func (f *syncCountingFile) Sync() error {
+	f.syncs++
+	return f.File.Sync()
+}
+
+func TestWriteBatch(t *testing.T) {
+	plain := openTemp(t, "batch.jsonl")
+	backend := &syncCountingFile{File: plain.f.(*os.File)}
+	store, err := Open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{1, 10, 100} {
+		backend.syncs = 0
+		batch := make([][]byte, size)
+		for i := range batch {
+			batch[i] = []byte(fmt.Sprintf(`{ "number": %d }`, i))
+		}
+		if _, err := store.WriteBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		if backend.syncs != 1 {
+			t.Fatalf("expected one sync for a batch of (%d), got (%d)", size, backend.syncs)
+		}
+	}
+	if b, _ := store.ReadBytes(); string(b) != `{"number":99}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":99}`, b)
+	}
+	if n, _ := store.Count(); n != 111 {
+		t.Fatalf("expected (111) entries, got (%d)", n)
+	}
+	_, err = store.WriteBatch([][]byte{[]byte(`{"number":0}`), []byte(`{"number":`)})
+	if !errors.Is(err, ErrNotJSON) || !strings.Contains(err.Error(), "entry 1") {
+		t.Fatalf("expected ErrNotJSON for entry 1, got (%v)", err)
+	}
+	if n, _ := store.Count(); n != 111 {
+		t.Fatal("expected nothing to be written for an invalid batch")
+	}
+}
+
+func TestAppendJSONValues(t *testing.T) {
+	store := openTemp(t, "values.jsonl")
+	vals := []json.RawMessage{
+		json.RawMessage(`{"number":0}`),
+		json.RawMessage(`[1, 2]`),
+		json.RawMessage(`"three"`),
+	}
+	if _, err := store.AppendJSONValues(vals...); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`{"number":0}`, `[1,2]`, `"three"`}
+	if len(entries) != len(want) {
+		t.Fatalf("expected (%d) entries, got (%d)", len(want), len(entries))
+	}
+	for i := range want {
+		if string(entries[i]) != want[i] {
+			t.Fatalf("got wrong entry (%d). Expected (%s), got (%s)", i, want[i], entries[i])
+		}
+	}
+	if _, err := store.AppendJSONValues(json.RawMessage(`{`)); !errors.Is(err, ErrNotJSON) {
+		t.Fatalf("expected ErrNotJSON, got (%v)", err)
+	}
+}
+
+// openTemp opens a new jsonl file named name in a temporary
+// directory, closing it when the test completes.
+func openTemp(t *testing.T, name string, opts ...Option) *Jsonl {
+	t.Helper()
+	store, err := OpenFile(filepath.Join(t.TempDir(), name), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+// writeEntries writes each entry to store.
+func writeEntries(t *testing.T, store *Jsonl, entries ...string) {
+	t.Helper()
+	for _, entry := range entries {
+		if _, err := store.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeIfPresent(t *testing.T) {
+	type Config struct {
+		Key string `json:"key"`
+	}
+	store := openTemp(t, "present.jsonl")
+
+	config := Config{Key: "default"}
+	ok, err := store.DecodeIfPresent(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected DecodeIfPresent() to report no entry on an empty store")
+	}
+	if config.Key != "default" {
+		t.Fatalf("expected the target to be untouched, got (%s)", config.Key)
+	}
+
+	writeEntries(t, store, `{"key":"value"}`)
+	ok, err = store.DecodeIfPresent(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected DecodeIfPresent() to report an entry")
+	}
+	if config.Key != "value" {
+		t.Fatalf("values don't match! Expected (%s), got (%s)", "value", config.Key)
+	}
+}
+
+// benchEntry returns a compact JSON entry of roughly size bytes.
+func benchEntry(size int) []byte {
+	pad := size - len(`{"pad":""}`)
+	if pad < 0 {
+		pad = 0
+	}
+	return []byte(`{"pad":"` + strings.Repeat("x", pad) + `"}`)
+}
+
+// benchStore returns a store in a temporary directory populated
+// with count copies of entry.
+func benchStore(b *testing.B, count int, entry []byte) *Jsonl {
+	b.Helper()
+	store, err := OpenFile(filepath.Join(b.TempDir(), "bench.jsonl"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = store.Close() })
+	// Populate the file directly, as syncing each entry is slow.
+	data := make([]byte, 0, count*(len(entry)+1))
+	for i := 0; i < count; i++ {
+		data = append(append(data, entry...), '\n')
+	}
+	if _, err := store.f.Write(data); err != nil {
+		b.Fatal(err)
+	}
+	return store
+}
+
+var benchCases = []struct {
+	name    string
+	entries int
+	size    int
+}{
+	{"small", 1, 64},
+	{"large", 1, 1024 * 1024},
+	{"many", 10000, 64},
+}
+
+func BenchmarkWrite(b *testing.B) {
+	for _, bc := range benchCases {
+		b.Run(bc.name, func(b *testing.B) {
+			entry := benchEntry(bc.size)
+			store := benchStore(b, bc.entries-1, entry)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(entry)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := store.Write(entry); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWriteBatch(b *testing.B) {
+	entry := benchEntry(64)
+	batch := make([][]byte, 100)
+	for i := range batch {
+		batch[i] = entry
+	}
+	store := benchStore(b, 0, entry)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(batch) * len(entry)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.WriteBatch(batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	for _, bc := range benchCases {
+		b.Run(bc.name, func(b *testing.B) {
+			entry := benchEntry(bc.size)
+			store := benchStore(b, bc.entries, entry)
+			p := make([]byte, len(entry))
+			b.ReportAllocs()
+			b.SetBytes(int64(len(entry)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := store.Read(p); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, bc := range benchCases {
+		b.Run(bc.name, func(b *testing.B) {
+			entry := benchEntry(bc.size)
+			store := benchStore(b, bc.entries, entry)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(entry)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var v struct {
+					Pad string `json:"pad"`
+				}
+				if err := store.Decode(&v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkUnmarshalReadBytes is the baseline for BenchmarkDecode,
+// copying the latest entry before decoding it.
+func BenchmarkUnmarshalReadBytes(b *testing.B) {
+	for _, bc := range benchCases {
+		b.Run(bc.name, func(b *testing.B) {
+			entry := benchEntry(bc.size)
+			store := benchStore(b, bc.entries, entry)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(entry)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var v struct {
+					Pad string `json:"pad"`
+				}
+				p, err := store.ReadBytes()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := json.Unmarshal(p, &v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadIntoPooled(t *testing.T) {
+	store := openTemp(t, "pooled.jsonl")
+	if err := store.ReadIntoPooled(func([]byte) error { return nil }); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty on an empty store, got (%v)", err)
+	}
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	var got string
+	err := store.ReadIntoPooled(func(entry []byte) error {
+		got = string(entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != `{"number":1}` {
+		t.Fatalf("got wrong entry from ReadIntoPooled(). Expected (%s), got (%s)", `{"number":1}`, got)
+	}
+}
+
+func BenchmarkReadBytes(b *testing.B) {
+	store := benchStore(b, 100, benchEntry(1024))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.ReadBytes(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadIntoPooled(b *testing.B) {
+	store := benchStore(b, 100, benchEntry(1024))
+	fn := func([]byte) error { return nil }
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := store.ReadIntoPooled(fn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSetMaxEntrySize(t *testing.T) {
+	store := openTemp(t, "maxentry.jsonl", WithMaxMemory(1024))
+	large := string(benchEntry(2048))
+	if _, err := store.Write([]byte(large)); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected ErrEntryTooLarge, got (%v)", err)
+	}
+	if err := store.SetMaxEntrySize(0); err == nil {
+		t.Fatal("expected an error setting a max entry size of 0")
+	}
+	if err := store.SetMaxEntrySize(4096); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, large)
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != large {
+		t.Fatal("got wrong entry from ReadBytes() after raising the max entry size")
+	}
+}
+
+func TestWriteLines(t *testing.T) {
+	store := openTemp(t, "lines.jsonl")
+	// A partial write which the batch must be demarcated from.
+	if _, err := store.f.Write([]byte(`{"num`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.WriteLines([]byte("{\"number\":0}\n\n{\"number\":1}\r\n{\"number\":2}")); err != nil {
+		t.Fatal(err)
+	}
+	count, err := store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected Count() of (%d), got (%d)", 3, count)
+	}
+
+	_, err = store.WriteLines([]byte("{\"number\":3}\n{\"number\":\n{\"number\":5}\n"))
+	if !errors.Is(err, ErrNotJSON) || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected ErrNotJSON reporting line 2, got (%v)", err)
+	}
+	count, err = store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected no entries to be written from an invalid batch, got Count() of (%d)", count)
+	}
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"number":2}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":2}`, latest)
+	}
+}
+
+func TestNoValidEntry(t *testing.T) {
+	for name, contents := range map[string]string{
+		"corrupt":      "{\"number\":\nnot json\n",
+		"partial":      `{"number":0`,
+		"corrupt tail": "not json\n{\"number\":0",
+	} {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "corrupt.jsonl")
+			if err := os.WriteFile(filename, []byte(contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			store, err := OpenFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			p := make([]byte, 64)
+			if _, err := store.Read(p); !errors.Is(err, ErrNoValidEntry) || errors.Is(err, io.EOF) {
+				t.Fatalf("expected ErrNoValidEntry from Read(), got (%v)", err)
+			}
+			if _, err := store.ReadBytes(); !errors.Is(err, ErrNoValidEntry) {
+				t.Fatalf("expected ErrNoValidEntry from ReadBytes(), got (%v)", err)
+			}
+		})
+	}
+	// Blank lines hold no data.
+	store := openTemp(t, "blank.jsonl")
+	if _, err := store.f.Write([]byte("\n  \n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(make([]byte, 64)); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF from a store of blank lines, got (%v)", err)
+	}
+}
+
+func TestValidateEntry(t *testing.T) {
+	store := openTemp(t, "validate.jsonl")
+	for name, p := range map[string][]byte{
+		"valid":    []byte(`{"number":1}`),
+		"invalid":  []byte(`{"number":`),
+		"non-utf8": []byte("\"\xff\""),
+		"empty":    nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateEntry(p)
+			if name == "valid" && err != nil {
+				t.Fatalf("expected nil from ValidateEntry(), got (%v)", err)
+			}
+			if name != "valid" && !errors.Is(err, ErrNotJSON) {
+				t.Fatalf("expected ErrNotJSON from ValidateEntry(), got (%v)", err)
+			}
+			if _, werr := store.Write(p); (werr == nil) != (err == nil) {
+				t.Fatalf("ValidateEntry() and Write() disagree. ValidateEntry (%v), Write (%v)", err, werr)
+			}
+		})
+	}
+}
+
+func TestFile(t *testing.T) {
+	store := openTemp(t, "file.jsonl")
+	f := store.File()
+	if f == nil || f != store.f {
+		t.Fatalf("expected File() to return the underlying file, got (%v)", f)
+	}
+	fd := f.Fd()
+	if got := store.File().Fd(); got != fd {
+		t.Fatalf("expected descriptor (%d), got (%d)", fd, got)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f := store.File(); f != nil {
+		t.Fatalf("expected nil from File() after Close(), got (%v)", f)
+	}
+	mem, err := OpenReader(strings.NewReader("{}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := mem.File(); f != nil {
+		t.Fatalf("expected nil from File() for an in-memory store, got (%v)", f)
+	}
+}
+
+func TestEmptyWrite(t *testing.T) {
+	store := openTemp(t, "empty.jsonl")
+	for name, p := range map[string][]byte{
+		"empty":      {},
+		"whitespace": []byte(" \t\n"),
+	} {
+		_, err := store.Write(p)
+		if !errors.Is(err, ErrEmptyWrite) || !errors.Is(err, ErrNotJSON) {
+			t.Fatalf("expected ErrEmptyWrite wrapping ErrNotJSON for %s input, got (%v)", name, err)
+		}
+	}
+	if _, err := store.Write([]byte("{")); err == nil || errors.Is(err, ErrEmptyWrite) {
+		t.Fatalf("expected ErrNotJSON but not ErrEmptyWrite for malformed JSON, got (%v)", err)
+	}
+	// null is valid JSON, not an empty write.
+	if _, err := store.Write([]byte("null")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := store.ReadBytes(); err != nil || string(b) != "null" {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (null), got (%s, %v)", b, err)
+	}
+}
+
+func TestLatestWithVersion(t *testing.T) {
+	store := openTemp(t, "version.jsonl")
+	if _, _, err := store.LatestWithVersion(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty, got (%v)", err)
+	}
+	for i := 0; i < 5; i++ {
+		entry := fmt.Sprintf(`{"number":%d}`, i)
+		writeEntries(t, store, entry)
+		latest, version, err := store.LatestWithVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := store.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != count-1 {
+			t.Fatalf("expected version (%d), got (%d)", count-1, version)
+		}
+		if string(latest) != entry {
+			t.Fatalf("got wrong entry from LatestWithVersion(). Expected (%s), got (%s)", entry, latest)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package jsonl
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// NewLazy returns a *Jsonl for the jsonl file filename which is not
+// opened until first used, such as by Read() or Write(), so that
+// handles may be constructed eagerly without holding descriptors.
+// Errors opening the file, or from opts, are returned by the first
+// operation. Close() releases nothing if the file was never opened.
+func NewLazy(filename string, opts ...Option) *Jsonl {
+	j := &Jsonl{
+		name:    filename,
+		mu:      &sync.RWMutex{},
+		changed: make(chan struct{}),
+		count:   -1,
+	}
+	l := &lazyFile{}
+	for _, opt := range opts {
+		if err := opt(&j.cfg); err != nil {
+			l.err = err
+			break
+		}
+	}
+	l.open = func() (File, error) {
+		// Open a handle on the file as OpenFile() does, to format the
+		// header and make any other checks on open, then adopt its
+		// state. The header is only read after the file is used, so
+		// updating its length here is safe.
+		opened, err := openFile(filename, 0, opts...)
+		if err != nil {
+			return nil, err
+		}
+		j.cfg.headerBytes, j.meta, j.mm = opened.cfg.headerBytes, opened.meta, opened.mm
+		j.seen.Store(opened.seen.Load())
+		j.durable.Store(opened.durable.Load())
+		return opened.f, nil
+	}
+	j.f = l
+	return j
+}
+
+// lazyFile is a File which is opened on first use.
+type lazyFile struct {
+	mu   sync.Mutex
+	open func() (File, error)
+	// f is the file once opened, which wraps osf if opened
+	// WithDirectIO().
+	f   File
+	osf *os.File
+	err error
+}
+
+// file returns the file, opening it if it has not been opened.
+func (l *lazyFile) file() (*os.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil && l.err == nil {
+		l.f, l.err = l.open()
+		switch f := l.f.(type) {
+		case *os.File:
+			l.osf = f
+		case *directFile:
+			l.osf = f.File
+		}
+	}
+	return l.osf, l.err
+}
+
+// opened reports whether the file has been opened.
+func (l *lazyFile) opened() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f != nil
+}
+
+func (l *lazyFile) ReadAt(p []byte, off int64) (int, error) {
+	f, err := l.file()
+	if err != nil {
+		return 0, err
+	}
+	return f.ReadAt(p, off)
+}
+
+func (l *lazyFile) Write(p []byte) (int, error) {
+	if _, err := l.file(); err != nil {
+		return 0, err
+	}
+	return l.f.Write(p)
+}
+
+func (l *lazyFile) WriteAt(p []byte, off int64) (int, error) {
+	f, err := l.file()
+	if err != nil {
+		return 0, err
+	}
+	return f.WriteAt(p, off)
+}
+
+func (l *lazyFile) Stat() (os.FileInfo, error) {
+	f, err := l.file()
+	if err != nil {
+		return nil, err
+	}
+	return f.Stat()
+}
+
+func (l *lazyFile) Sync() error {
+	f, err := l.file()
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (l *lazyFile) Truncate(size int64) error {
+	f, err := l.file()
+	if err != nil {
+		return err
+	}
+	return f.Truncate(size)
+}
+
+func (l *lazyFile) SetReadDeadline(t time.Time) error {
+	f, err := l.file()
+	if err != nil {
+		return err
+	}
+	return f.SetReadDeadline(t)
+}
+
+// Fd returns the descriptor of the file, for locking and mapping it.
+func (l *lazyFile) Fd() uintptr {
+	f, err := l.file()
+	if err != nil {
+		return ^uintptr(0)
+	}
+	return f.Fd()
+}
+
+// Close closes the file if it was opened, and otherwise prevents it
+// from being opened.
+func (l *lazyFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		if l.err == nil {
+			l.err = os.ErrClosed
+		}
+		return nil
+	}
+	return l.f.Close()
+}
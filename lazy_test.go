@@ -0,0 +1,58 @@
+package jsonl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewLazy(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "lazy.jsonl")
+	store := NewLazy(filename, WithHeader())
+	defer store.Close()
+	if _, err := os.Stat(filename); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the file not to be opened before use, got (%v)", err)
+	}
+	if store.f.(*lazyFile).opened() {
+		t.Fatal("expected no descriptor before use")
+	}
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	if !store.f.(*lazyFile).opened() {
+		t.Fatal("expected the file to be opened by Write()")
+	}
+	b, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"number":1}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":1}`, b)
+	}
+	if n, err := store.Count(); err != nil || n != 2 {
+		t.Fatalf("expected (2) entries after the header, got (%d, %v)", n, err)
+	}
+
+	// A handle which is never used is closed without opening the file.
+	unused := filepath.Join(t.TempDir(), "unused.jsonl")
+	if err := NewLazy(unused, WithCompactOnClose()).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(unused); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected Close() not to open the file, got (%v)", err)
+	}
+}
+
+func TestNewLazyWithTruncateOnOpen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(filename, []byte("{\"number\":0}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewLazy(filename, WithTruncateOnOpen())
+	defer store.Close()
+	if _, err := store.Latest(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty from a truncated store, got (%v)", err)
+	}
+	if stat, err := os.Stat(filename); err != nil || stat.Size() != 0 {
+		t.Fatalf("expected an empty file, got (%v, %v)", stat, err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package jsonl
+
+// lockFile is a no-op on platforms without flock(2).
+func lockFile(f File) (func(), error) {
+	return func() {}, nil
+}
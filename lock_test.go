@@ -0,0 +1,55 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentHandles(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "handles.jsonl")
+	const writers, writes = 2, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		store, err := OpenFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		wg.Add(1)
+		go func(w int, store *Jsonl) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				entry := fmt.Sprintf(`{"writer":%d,"number":%d}`, w, i)
+				if _, err := store.Write([]byte(entry)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w, store)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != writers*writes {
+		t.Fatalf("expected (%d) lines, got (%d)", writers*writes, len(lines))
+	}
+	for i, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Fatalf("line (%d) is not valid JSON: %q", i+1, line)
+		}
+	}
+}
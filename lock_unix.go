@@ -0,0 +1,24 @@
+//go:build unix
+
+package jsonl
+
+import "syscall"
+
+// lockFile takes an exclusive advisory lock on f, if it has a file
+// descriptor, returning a func which releases it.
+func lockFile(f File) (func(), error) {
+	fd, ok := f.(interface{ Fd() uintptr })
+	if !ok {
+		return func() {}, nil
+	}
+	for {
+		err := syscall.Flock(int(fd.Fd()), syscall.LOCK_EX)
+		if err == nil {
+			break
+		}
+		if err != syscall.EINTR {
+			return nil, err
+		}
+	}
+	return func() { _ = syscall.Flock(int(fd.Fd()), syscall.LOCK_UN) }, nil
+}
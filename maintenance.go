@@ -0,0 +1,492 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ErrCorrupt is returned by Verify() if an entry before the end
+// of the file is not valid JSON.
+var ErrCorrupt = fmt.Errorf("jsonl: corrupt entry")
+
+// Verify checks that every line in the file is a valid entry,
+// returning an error wrapping ErrCorrupt which reports the line
+// number of the first corrupt entry. Blank lines, and a partial
+// write at the end of the file, are not considered corrupt as
+// reads recover from them.
+func (j *Jsonl) Verify() error {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.verify("Verify")
+}
+
+// verify implements Verify(). The caller must hold j.mu.
+func (j *Jsonl) verify(op string) error {
+	view, err := j.view(op)
+	if err != nil {
+		return err
+	}
+	line := 0
+	return view.scanForward(func(off int64, b []byte) error {
+		line++
+		if e, ok := entry(b); !ok && len(e) > 0 {
+			return fmt.Errorf("%w: line %d at offset %d", ErrCorrupt, line, off)
+		}
+		return nil
+	})
+}
+
+// Range is a region of the file.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// CorruptRanges returns the regions of the file holding corrupt
+// data, oldest first, for analysis after a disk or power failure.
+// Consecutive corrupt lines are reported as one region. A partial
+// write at the end of the file is reported as the last region.
+func (j *Jsonl) CorruptRanges() ([]Range, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	view, err := j.view("CorruptRanges")
+	if err != nil {
+		return nil, err
+	}
+	var ranges []Range
+	// next is the offset following the last line scanned.
+	next := view.start
+	corrupt := false
+	err = view.scanForward(func(off int64, line []byte) error {
+		next = off + int64(len(line)) + 1
+		e, ok := entry(line)
+		switch {
+		case ok || len(e) == 0:
+			corrupt = false
+		case corrupt:
+			// Extend the previous region over this line.
+			r := &ranges[len(ranges)-1]
+			r.Length = off + int64(len(line)) - r.Offset
+		default:
+			corrupt = true
+			ranges = append(ranges, Range{Offset: off, Length: int64(len(line))})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if view.size > next {
+		ranges = append(ranges, Range{Offset: next, Length: view.size - next})
+	}
+	return ranges, nil
+}
+
+// Compact atomically rewrites the file so that it holds only the
+// latest entry, discarding the history and any corrupt entries.
+func (j *Jsonl) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compact("Compact")
+}
+
+// CompactAsync compacts the file as Compact() does in another
+// goroutine, so as not to block the caller, returning a channel which
+// receives the result once it's done. Compactions are serialized with
+// each other and with writes, and Close() waits for them to finish,
+// so CompactAsync() must not be called concurrently with Close().
+func (j *Jsonl) CompactAsync() <-chan error {
+	done := make(chan error, 1)
+	j.async.Add(1)
+	go func() {
+		defer j.async.Done()
+		done <- j.Compact()
+	}()
+	return done
+}
+
+// ReclaimableBytes returns the number of bytes by which Compact()
+// would shrink the file, to judge whether compacting is worth the
+// rewrite. Only the latest entry is read, by a backward scan as
+// Read() does.
+func (j *Jsonl) ReclaimableBytes() (int64, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	view, err := j.view("ReclaimableBytes")
+	if err != nil {
+		return 0, err
+	}
+	kept := int64(0)
+	err = view.stored().newestEntries(func(_ int64, entry []byte) error {
+		// Compact() rewrites the entry trimmed, and aligned if the
+		// entries are.
+		kept = int64(len(entry)) + 1
+		if n := int64(j.cfg.alignment); n > 1 && kept%n != 0 {
+			kept += n - kept%n
+		}
+		return errStop
+	})
+	if err != nil {
+		return 0, err
+	}
+	return max(view.size-view.start-kept, 0), nil
+}
+
+// compact implements Compact(). The caller must hold j.mu for
+// writing.
+func (j *Jsonl) compact(op string) error {
+	return j.rewrite(op, func(view view, emit func(src int64, entry []byte) error) error {
+		return view.newestEntries(func(off int64, entry []byte) error {
+			if err := emit(off, entry); err != nil {
+				return err
+			}
+			return errStop
+		})
+	})
+}
+
+// Normalize rewrites the file with one entry per line, for files
+// written by tools which concatenate JSON values without newlines or
+// pretty-print them across lines. The file is decoded as a stream of
+// JSON values, each of which becomes an entry, compacted. It returns
+// the number of entries which did not already occupy a line of their
+// own. A partial write at the end of the file is dropped, but if the
+// file holds data which isn't JSON, an error wrapping ErrCorrupt is
+// returned and the file is unchanged. The file is rewritten
+// atomically.
+func (j *Jsonl) Normalize() (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	reframed := 0
+	err := j.rewrite("Normalize", func(view view, emit func(src int64, entry []byte) error) error {
+		framed := 0
+		err := view.entries(func(_ int64, _ []byte) error {
+			framed++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(io.NewSectionReader(view.f, view.start, view.size-view.start))
+		var buf bytes.Buffer
+		values := 0
+		for {
+			var raw json.RawMessage
+			err := dec.Decode(&raw)
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				return fmt.Errorf("%w: at offset %d", ErrCorrupt, view.start+syntax.Offset)
+			}
+			if err != nil {
+				return err
+			}
+			buf.Reset()
+			if err := json.Compact(&buf, raw); err != nil {
+				return err
+			}
+			src := view.start + dec.InputOffset() - int64(len(raw))
+			if err := emit(src, buf.Bytes()); err != nil {
+				return err
+			}
+			values++
+		}
+		reframed = values - framed
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return reframed, nil
+}
+
+// prepend rewrites the file with the entries p, which end in a
+// newline, before the existing entries, for WithNewestFirst(). The
+// entries of p are written newest first too. The caller must hold
+// j.mu for writing.
+func (j *Jsonl) prepend(p []byte) (int, error) {
+	lines := bytes.SplitAfter(p[:len(p)-1], []byte("\n"))
+	err := j.rewrite("Write", func(view view, emit func(src int64, entry []byte) error) error {
+		for i := len(lines) - 1; i >= 0; i-- {
+			if err := emit(-1, bytes.TrimSuffix(lines[i], []byte("\n"))); err != nil {
+				return err
+			}
+		}
+		return view.entries(emit)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Prune removes the entries whose top-level field holds a timestamp
+// before the given time, returning the number of entries removed.
+// Entries which lack the field, or whose field is not an RFC 3339
+// timestamp, are kept. The file is rewritten atomically, dropping
+// any corrupt entries.
+func (j *Jsonl) Prune(field string, before time.Time) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	removed := 0
+	err := j.rewrite("Prune", func(view view, emit func(src int64, entry []byte) error) error {
+		return view.entries(func(off int64, entry []byte) error {
+			if v, ok := lookup(entry, field); ok {
+				var ts time.Time
+				if err := json.Unmarshal(v, &ts); err == nil && ts.Before(before) {
+					removed++
+					return nil
+				}
+			}
+			return emit(off, entry)
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// Maintenance applies the chain of fns to every entry in a single
+// scan, rewriting the file once atomically with the result. Each fn
+// returns whether to keep the entry, and optionally a replacement
+// for it which is passed to the following fns. A dropped entry is
+// not passed to the following fns. If any fn returns an error the
+// file is left unchanged and the error is returned. This composes
+// filtering, deduplication and transformation into one rewrite. The
+// store is locked while fns run, so they must not use it.
+func (j *Jsonl) Maintenance(fns ...func(entry []byte) (keep bool, replacement []byte, err error)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.rewrite("Maintenance", func(view view, emit func(src int64, entry []byte) error) error {
+		return view.entries(func(off int64, entry []byte) error {
+			for _, fn := range fns {
+				var keep bool
+				var replacement []byte
+				err := call(func() (err error) {
+					keep, replacement, err = fn(entry)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				if !keep {
+					return nil
+				}
+				if replacement != nil {
+					if !validJSON(replacement) {
+						return fmt.Errorf("%w: replacement for entry %q", ErrNotJSON, entry)
+					}
+					var buf bytes.Buffer
+					if err := json.Compact(&buf, bytes.TrimSpace(replacement)); err != nil {
+						return ErrNotJSON
+					}
+					entry = buf.Bytes()
+				}
+			}
+			return emit(off, entry)
+		})
+	})
+}
+
+// rewrite atomically replaces the entries in the file with those
+// passed to emit by fn, which is given a view of the current
+// entries. Each emitted entry is passed with the offset of the entry
+// it derives from, so that the sidecar, if any, can follow it, or a
+// negative offset if it derives from none, such as the elements
+// split out by Flatten(), in which case it has no metadata. The
+// header, if any, is preserved. The new file is written
+// beside the old one, synced, and renamed over it, so a power loss
+// leaves either the old or the new file intact. Errors are returned
+// as *OpError for op. The caller must hold j.mu for writing.
+func (j *Jsonl) rewrite(op string, fn func(view view, emit func(src int64, entry []byte) error) error) (err error) {
+	defer func() { err = opError(op, err) }()
+	if j.name == "" {
+		return fmt.Errorf("jsonl: rewriting requires a named file: %w", errors.ErrUnsupported)
+	}
+	view, err := j.view(op)
+	if err != nil {
+		return err
+	}
+	// Entries are rewritten as stored, not as read.
+	view = view.stored()
+	dir := filepath.Dir(j.name)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(j.name)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("jsonl failed to create a temporary file: %w", err)
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	w := bufio.NewWriter(tmp)
+	if view.start > 0 {
+		if _, err := io.Copy(w, io.NewSectionReader(view.f, 0, view.start)); err != nil {
+			return fmt.Errorf("jsonl failed to copy the header: %w", err)
+		}
+	}
+	var moved map[int64]Meta
+	if j.meta != nil {
+		moved = make(map[int64]Meta)
+	}
+	pos, count := view.start, 0
+	err = fn(view, func(src int64, entry []byte) error {
+		if moved != nil && src >= 0 {
+			moved[src] = Meta{Index: count, Offset: pos}
+		}
+		line := append(entry[:len(entry):len(entry)], '\n')
+		if j.cfg.alignment > 1 {
+			// Keep the entries aligned as they were written.
+			line = j.align(line)
+		}
+		pos += int64(len(line))
+		count++
+		_, err := w.Write(line)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if stat, err := j.f.Stat(); err == nil {
+		if err := tmp.Chmod(stat.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), j.name); err != nil {
+		return fmt.Errorf("jsonl failed to replace the file: %w", err)
+	}
+	renamed = true
+	// The file is replaced regardless, but the rename may not be
+	// durable. The store must follow the new file either way, as
+	// writes to the old one would be lost.
+	syncErr := syncDir(dir)
+	old := j.f
+	f, err := os.OpenFile(j.name, j.cfg.openFlag()&^os.O_CREATE, 0o600)
+	if err != nil {
+		err = fmt.Errorf("jsonl failed to reopen the file: %w", err)
+		j.f = lostFile{err: err}
+		j.count = -1
+		return errors.Join(syncErr, err, j.retire(j.closer(old)))
+	}
+	j.f = j.wrapDirect(f)
+	j.count, j.countSize = count, pos
+	j.seen.Store(pos)
+	j.durable.Store(pos)
+	j.generation++
+	j.rewritten = pos
+	j.notify()
+	err = errors.Join(syncErr, j.retire(j.closer(old)))
+	if j.meta != nil {
+		err = errors.Join(err, j.meta.move(moved))
+	}
+	return err
+}
+
+// MoveTo moves the file to newPath and reopens the store there, such
+// as to move a store from tmpfs to persistent storage. Moves across
+// filesystems copy the file and remove the original. If the move
+// fails the store is reopened at its current path.
+func (j *Jsonl) MoveTo(newPath string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.name == "" {
+		return fmt.Errorf("jsonl: moving requires a named file: %w", errors.ErrUnsupported)
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	stat, err := j.stat("MoveTo")
+	if err != nil {
+		return err
+	}
+	if err := j.retire(j.closer(j.f)); err != nil {
+		return err
+	}
+	var syncErr error
+	moveErr := move(j.name, newPath, stat.Mode().Perm())
+	if moveErr == nil {
+		// The file has moved regardless, but the move may not be
+		// durable.
+		syncErr = errors.Join(syncDir(filepath.Dir(j.name)), syncDir(filepath.Dir(newPath)))
+	} else {
+		// The original file remains, so reopen it.
+		newPath = j.name
+	}
+	// The file is never created, so a failure can't leave the store
+	// empty at a path other than its file's.
+	f, err := os.OpenFile(newPath, j.cfg.openFlag()&^os.O_CREATE, 0o600)
+	if err != nil {
+		return errors.Join(moveErr, syncErr, fmt.Errorf("jsonl failed to reopen the file: %w", err))
+	}
+	j.f = j.wrapDirect(f)
+	j.name = newPath
+	if moveErr == nil && j.meta != nil {
+		syncErr = errors.Join(syncErr, j.meta.MoveTo(sidecarName(newPath)))
+	}
+	return errors.Join(moveErr, syncErr)
+}
+
+// rename is os.Rename, replaced by tests to simulate moves across
+// filesystems.
+var rename = os.Rename
+
+// move renames oldPath to newPath, falling back to copying the file
+// and removing the original if they're on different filesystems.
+func move(oldPath, newPath string, perm os.FileMode) error {
+	err := rename(oldPath, newPath)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	src, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(newPath)
+		return fmt.Errorf("jsonl failed to copy the file: %w", err)
+	}
+	if err := errors.Join(dst.Sync(), dst.Close()); err != nil {
+		_ = os.Remove(newPath)
+		return err
+	}
+	return os.Remove(oldPath)
+}
+
+// syncDir syncs the directory dir so that renames within it are
+// durable. It is replaced by tests to simulate failures.
+var syncDir = func(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
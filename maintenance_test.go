@@ -0,0 +1,490 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	store := openTemp(t, "prune.jsonl")
+	now := time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)
+	for day := 7; day >= 1; day-- {
+		ts := now.Add(-time.Duration(day) * 24 * time.Hour).Format(time.RFC3339)
+		writeEntries(t, store, fmt.Sprintf(`{"day":%d,"ts":%q}`, day, ts))
+	}
+	writeEntries(t, store, `{"day":0}`)
+
+	removed, err := store.Prune("ts", now.Add(-3*24*time.Hour-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Days 7 through 4 are older than the cutoff.
+	if removed != 4 {
+		t.Fatalf("expected (%d) entries removed, got (%d)", 4, removed)
+	}
+	var got []string
+	for entry, err := range store.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(entry))
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected (%d) remaining entries, got (%d): %v", 4, len(got), got)
+	}
+	if got[len(got)-1] != `{"day":0}` {
+		t.Fatalf("expected the entry without a timestamp to be kept, got (%s)", got[len(got)-1])
+	}
+
+	// The store remains writable after the rewrite.
+	writeEntries(t, store, `{"day":-1}`)
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"day":-1}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"day":-1}`, latest)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	store := openTemp(t, "compact.jsonl")
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`, `{"number":2}`)
+	if _, err := store.f.Write([]byte(`{"number":`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	count, err := store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected Count() of (%d) after Compact(), got (%d)", 1, count)
+	}
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"number":2}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":2}`, latest)
+	}
+}
+
+func TestReclaimableBytes(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"plain":   nil,
+		"aligned": {WithWriteAlignment(64)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := openTemp(t, "reclaim.jsonl", opts...)
+			writeEntries(t, store, `{"number":0}`, `{"number":1}`, `{"number":2}`)
+			if _, err := store.f.Write([]byte(`{"number":`)); err != nil {
+				t.Fatal(err)
+			}
+			size := func() int64 {
+				t.Helper()
+				stat, err := os.Stat(store.name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return stat.Size()
+			}
+			before := size()
+			reclaimable, err := store.ReclaimableBytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			if got := before - size(); got != reclaimable {
+				t.Fatalf("got wrong estimate from ReclaimableBytes(). Compact() freed (%d), estimated (%d)", got, reclaimable)
+			}
+			if reclaimable, err := store.ReclaimableBytes(); err != nil || reclaimable != 0 {
+				t.Fatalf("expected nothing reclaimable after Compact(), got (%d, %v)", reclaimable, err)
+			}
+		})
+	}
+}
+
+func TestCompactAsync(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "compactasync.jsonl")
+	store, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		writeEntries(t, store, fmt.Sprintf(`{"number":%d}`, i))
+	}
+	before, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-store.CompactAsync(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Fatalf("expected the file to shrink from (%d) bytes, got (%d)", before.Size(), after.Size())
+	}
+	// Close() waits for a pending compaction.
+	writeEntries(t, store, `{"number":100}`)
+	done := store.CompactAsync()
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the compaction to finish before Close(), got (%v)", err)
+		}
+	default:
+		t.Fatal("expected Close() to wait for the compaction")
+	}
+}
+
+func TestWriteDuringCompact(t *testing.T) {
+	store := openTemp(t, "compactwrite.jsonl")
+	writeEntries(t, store, `{"number":0}`)
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := store.Compact(); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 1; i <= 100; i++ {
+		if _, err := store.Write([]byte(fmt.Sprintf(`{"number":%d}`, i))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.WriteBatch([][]byte{[]byte(fmt.Sprintf(`{"batch":%d}`, i))}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Encode(map[string]int{"number": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if b, err := store.ReadBytes(); err != nil || string(b) != `{"number":100}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", `{"number":100}`, b, err)
+	}
+}
+
+func TestWithCompactOnClose(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "compactonclose.jsonl")
+	store, err := OpenFile(filename, WithCompactOnClose())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`, `{"number":2}`)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"number\":2}\n" {
+		t.Fatalf("expected only the latest entry to remain, got (%q)", b)
+	}
+}
+
+func TestCorruptRanges(t *testing.T) {
+	store := openTemp(t, "corrupt.jsonl")
+	writeEntries(t, store, `{"number":0}`)
+	// An interrupted write, demarcated by the newline injected on the
+	// next Write().
+	if _, err := store.f.Write([]byte(`{"num`)); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":1}`)
+	// A partial write at the tail.
+	if _, err := store.f.Write([]byte(`{"number":2,"pad"`)); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges, err := store.CorruptRanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Range{
+		{Offset: int64(len("{\"number\":0}\n")), Length: int64(len(`{"num`))},
+		{Offset: int64(len("{\"number\":0}\n{\"num\n{\"number\":1}\n")), Length: int64(len(`{"number":2,"pad"`))},
+	}
+	if len(ranges) != len(want) {
+		t.Fatalf("expected (%d) corrupt ranges, got (%+v)", len(want), ranges)
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Fatalf("got wrong corrupt range (%d). Expected (%+v), got (%+v)", i, want[i], ranges[i])
+		}
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	type maintenanceFunc = func([]byte) (bool, []byte, error)
+	// Drop odd numbers.
+	var filter maintenanceFunc = func(entry []byte) (bool, []byte, error) {
+		var e struct{ Number int }
+		if err := json.Unmarshal(entry, &e); err != nil {
+			return false, nil, err
+		}
+		return e.Number%2 == 0, nil, nil
+	}
+	// Double the remaining numbers.
+	var transform maintenanceFunc = func(entry []byte) (bool, []byte, error) {
+		var e struct{ Number int }
+		if err := json.Unmarshal(entry, &e); err != nil {
+			return false, nil, err
+		}
+		return true, []byte(fmt.Sprintf(`{ "number": %d }`, e.Number*2)), nil
+	}
+	populate := func(store *Jsonl) {
+		for i := 0; i < 6; i++ {
+			writeEntries(t, store, fmt.Sprintf(`{"number":%d}`, i))
+		}
+	}
+	entries := func(store *Jsonl) []string {
+		var got []string
+		for entry, err := range store.All() {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(entry))
+		}
+		return got
+	}
+
+	chained := openTemp(t, "chained.jsonl")
+	populate(chained)
+	if err := chained.Maintenance(filter, transform); err != nil {
+		t.Fatal(err)
+	}
+	sequential := openTemp(t, "sequential.jsonl")
+	populate(sequential)
+	if err := sequential.Maintenance(filter); err != nil {
+		t.Fatal(err)
+	}
+	if err := sequential.Maintenance(transform); err != nil {
+		t.Fatal(err)
+	}
+
+	got, want := entries(chained), entries(sequential)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("chained maintenance (%v) differs from sequential (%v)", got, want)
+	}
+	if strings.Join(got, ",") != `{"number":0},{"number":4},{"number":8}` {
+		t.Fatalf("got wrong entries after maintenance: %v", got)
+	}
+
+	// An error leaves the file unchanged.
+	fail := func([]byte) (bool, []byte, error) { return false, nil, errors.New("failed") }
+	if err := chained.Maintenance(filter, fail); err == nil {
+		t.Fatal("expected an error from Maintenance()")
+	}
+	if got := entries(chained); len(got) != 3 {
+		t.Fatalf("expected the entries to be unchanged after a failed Maintenance(), got (%v)", got)
+	}
+}
+
+func TestMoveTo(t *testing.T) {
+	for _, crossDevice := range []bool{false, true} {
+		t.Run(fmt.Sprintf("crossDevice=%t", crossDevice), func(t *testing.T) {
+			if crossDevice {
+				rename = func(oldPath, newPath string) error {
+					return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EXDEV}
+				}
+				defer func() { rename = os.Rename }()
+			}
+			store := openTemp(t, "move.jsonl")
+			writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+			oldPath := store.name
+			newPath := filepath.Join(t.TempDir(), "moved.jsonl")
+			if err := store.MoveTo(newPath); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(oldPath); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("expected the original file to be removed, got (%v)", err)
+			}
+			writeEntries(t, store, `{"number":2}`)
+			b, err := os.ReadFile(newPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "{\"number\":0}\n{\"number\":1}\n{\"number\":2}\n"; string(b) != want {
+				t.Fatalf("got wrong contents after MoveTo(). Expected (%q), got (%q)", want, b)
+			}
+		})
+	}
+}
+
+func TestMoveToFailure(t *testing.T) {
+	store := openTemp(t, "move.jsonl")
+	writeEntries(t, store, `{"number":0}`)
+	if err := store.MoveTo(filepath.Join(t.TempDir(), "missing", "moved.jsonl")); err == nil {
+		t.Fatal("expected an error moving into a missing directory")
+	}
+	// The store must remain usable at its original path.
+	writeEntries(t, store, `{"number":1}`)
+	if n, err := store.Count(); err != nil || n != 2 {
+		t.Fatalf("expected (2) entries after a failed move, got (%d, %v)", n, err)
+	}
+}
+
+func TestMoveToSyncFailure(t *testing.T) {
+	orig := syncDir
+	syncDir = func(string) error { return syscall.EIO }
+	defer func() { syncDir = orig }()
+	store := openTemp(t, "move.jsonl")
+	writeEntries(t, store, `{"number":0}`)
+	oldPath := store.name
+	newPath := filepath.Join(t.TempDir(), "moved.jsonl")
+	if err := store.MoveTo(newPath); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected the sync error from MoveTo(), got (%v)", err)
+	}
+	// The store follows the file, rather than recreating it empty.
+	if _, err := os.Stat(oldPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected nothing at the original path, got (%v)", err)
+	}
+	writeEntries(t, store, `{"number":1}`)
+	b, err := os.ReadFile(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"number\":0}\n{\"number\":1}\n"; string(b) != want {
+		t.Fatalf("got wrong contents after MoveTo(). Expected (%q), got (%q)", want, b)
+	}
+}
+
+func TestCompactSyncFailure(t *testing.T) {
+	orig := syncDir
+	defer func() { syncDir = orig }()
+	syncDir = func(string) error { return syscall.EIO }
+	store := openTemp(t, "compact.jsonl")
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	if err := store.Compact(); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected the sync error from Compact(), got (%v)", err)
+	}
+	// Writes go to the new file, rather than the replaced one.
+	writeEntries(t, store, `{"number":2}`)
+	b, err := os.ReadFile(store.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"number\":1}\n{\"number\":2}\n"; string(b) != want {
+		t.Fatalf("got wrong contents after Compact(). Expected (%q), got (%q)", want, b)
+	}
+
+	// If the new file can't be reopened, writes fail rather than
+	// going to the replaced file.
+	syncDir = func(string) error { return os.Remove(store.name) }
+	if err := store.Compact(); err == nil {
+		t.Fatal("expected an error from Compact() failing to reopen the file")
+	}
+	if _, err := store.Write([]byte(`{"number":3}`)); err == nil {
+		t.Fatal("expected an error writing after the file was lost")
+	}
+}
+
+func TestOpenFileWithReport(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "report.jsonl")
+	contents := "{\"number\":0}\n{\"number\":1}\n"
+	partial := `{"number":2,"pa`
+	if err := os.WriteFile(filename, []byte(contents+partial), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, report, err := OpenFileWithReport(filename, WithTruncateTrailingGarbage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	want := RecoveryReport{TruncatedBytes: int64(len(partial)), CorruptTail: true, EntriesBefore: 2, EntriesAfter: 2}
+	if report != want {
+		t.Fatalf("got wrong report. Expected (%+v), got (%+v)", want, report)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != contents {
+		t.Fatalf("expected the partial write to be truncated, got (%q)", b)
+	}
+
+	// Without healing, the corrupt tail is only reported.
+	if err := os.WriteFile(filename, []byte(contents+partial), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	plain, report, err := OpenFileWithReport(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	want.TruncatedBytes = 0
+	if report != want {
+		t.Fatalf("got wrong report. Expected (%+v), got (%+v)", want, report)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	store := openTemp(t, "normalize.jsonl")
+	if _, err := store.f.Write([]byte(`{"number":0}{"number":1} {"number":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := store.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected (%d) entries re-framed, got (%d)", 3, n)
+	}
+	got, err := collect(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"number":0},{"number":1},{"number":2}`
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got wrong entries after Normalize(). Expected (%s), got (%s)", want, strings.Join(got, ","))
+	}
+	b, err := os.ReadFile(store.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"number\":0}\n{\"number\":1}\n{\"number\":2}\n" {
+		t.Fatalf("got wrong file contents after Normalize() (%q)", b)
+	}
+	// Well-framed entries aren't counted, pretty-printed ones are, and a
+	// partial write is dropped.
+	if _, err := store.f.Write([]byte("{\n  \"number\": 3\n}\n{\"num")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.Normalize(); err != nil || n != 1 {
+		t.Fatalf("expected (%d) entries re-framed, got (%d, %v)", 1, n, err)
+	}
+	if count, err := store.Count(); err != nil || count != 4 {
+		t.Fatalf("expected Count() of (%d), got (%d, %v)", 4, count, err)
+	}
+	// Data which isn't JSON leaves the file unchanged.
+	if _, err := store.f.Write([]byte("not json\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Normalize(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got (%v)", err)
+	}
+}
@@ -0,0 +1,12 @@
+package jsonl
+
+// Metrics receives measurements of a store, for export to a metrics
+// system such as expvar or Prometheus. Its methods may be called
+// concurrently, and must not use the store. A panic is recovered and
+// returned as an error wrapping ErrPanic.
+type Metrics interface {
+	// ObserveWriteSize is called with the size in bytes of each entry
+	// written, including its newline and any alignment padding, such
+	// as to record the distribution of entry sizes in a histogram.
+	ObserveWriteSize(n int)
+}
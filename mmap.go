@@ -0,0 +1,157 @@
+package jsonl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// minMapSize is the smallest region mapped by WithMmap(). Mappings
+// are rounded up to a power of two so that appends rarely require
+// the file to be mapped again.
+const minMapSize = 64 * 1024
+
+// mmapState holds the mappings of a store opened WithMmap().
+type mmapState struct {
+	mu sync.Mutex
+	// f is the file currently mapped by data, which may extend past
+	// the end of the file.
+	f    File
+	data []byte
+	// maps holds every mapping made of the files still held,
+	// including data. Older mappings of a file may still be used by
+	// reads in progress, so they are released with the file.
+	maps []mapping
+}
+
+// mapping is a mapping of the file f.
+type mapping struct {
+	f    File
+	data []byte
+}
+
+// readerAt returns a reader over the first size bytes of f which
+// reads from a mapping of the file, or f itself if it cannot be
+// mapped.
+func (m *mmapState) readerAt(f File, size int64) io.ReaderAt {
+	if size <= 0 {
+		return f
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.f != f || int64(len(m.data)) < size {
+		n := int64(minMapSize)
+		for n < size {
+			n *= 2
+		}
+		data, err := mmap(f, n)
+		if err != nil {
+			return f
+		}
+		m.f, m.data = f, data
+		m.maps = append(m.maps, mapping{f: f, data: data})
+	}
+	return mapped(m.data[:size])
+}
+
+// close releases all mappings.
+func (m *mmapState) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	for _, mm := range m.maps {
+		if e := munmap(mm.data); e != nil && err == nil {
+			err = e
+		}
+	}
+	m.f, m.data, m.maps = nil, nil, nil
+	return err
+}
+
+// release releases the mappings of f, once the store has replaced it
+// and reads of it are done.
+func (m *mmapState) release(f File) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	kept := m.maps[:0]
+	for _, mm := range m.maps {
+		if mm.f != f {
+			kept = append(kept, mm)
+			continue
+		}
+		if e := munmap(mm.data); e != nil && err == nil {
+			err = e
+		}
+	}
+	clear(m.maps[len(kept):])
+	m.maps = kept
+	if m.f == f {
+		m.f, m.data = nil, nil
+	}
+	return err
+}
+
+// OpenSharedReadOnly opens the jsonl file filename read-only through
+// a memory mapping, so reads make no syscalls and processes reading
+// the same file share the page cache rather than each holding their
+// own copy. The mapping covers the file as it was when opened, so the
+// store must be reopened to see later writes. Write() returns
+// ErrReadOnly. Where mapping is unsupported the file is read into
+// memory instead.
+func OpenSharedReadOnly(filename string, opts ...Option) (*Jsonl, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	m := &memFile{Reader: bytes.NewReader(nil), closer: f}
+	if stat.Size() > 0 {
+		data, err := mmap(f, stat.Size())
+		if errors.Is(err, errors.ErrUnsupported) {
+			return OpenReader(f, opts...)
+		}
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("jsonl failed to map the file: %w", err)
+		}
+		m = &memFile{Reader: bytes.NewReader(data), closer: &unmapper{data: data, f: f}}
+	}
+	j, err := open(m, opts...)
+	if err != nil {
+		_ = m.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// unmapper closes a file mapped by OpenSharedReadOnly().
+type unmapper struct {
+	data []byte
+	f    *os.File
+}
+
+func (u *unmapper) Close() error {
+	return errors.Join(munmap(u.data), u.f.Close())
+}
+
+// mapped is an io.ReaderAt over a mapped region of a file.
+type mapped []byte
+
+func (m mapped) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
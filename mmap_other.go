@@ -0,0 +1,14 @@
+//go:build !unix
+
+package jsonl
+
+import "errors"
+
+// mmap is unsupported on this platform, so reads use ReadAt().
+func mmap(f File, size int64) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func munmap(b []byte) error {
+	return nil
+}
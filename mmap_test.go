@@ -0,0 +1,140 @@
+package jsonl
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWithMmap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("mmap is unsupported")
+	}
+	filename := filepath.Join(t.TempDir(), "mmap.jsonl")
+	mapped, err := OpenFile(filename, WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.Close()
+	plain, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+
+	compare := func() {
+		t.Helper()
+		want, err := collect(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := collect(mapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("got different entries from mmap reads. Expected (%d) entries, got (%d)", len(want), len(got))
+		}
+		wantLatest, wantErr := plain.ReadBytes()
+		gotLatest, gotErr := mapped.ReadBytes()
+		if string(gotLatest) != string(wantLatest) || (gotErr == nil) != (wantErr == nil) {
+			t.Fatalf("got wrong entry from mmap ReadBytes(). Expected (%s), got (%s)", wantLatest, gotLatest)
+		}
+		n, err := mapped.Count()
+		if err != nil || n != len(want) {
+			t.Fatalf("got wrong Count() from mmap reads. Expected (%d), got (%d, %v)", len(want), n, err)
+		}
+	}
+	compare()
+	writeEntries(t, mapped, `{"number":0}`)
+	compare()
+	// Grow the file past the first mapping, with a partial write at
+	// the end.
+	pad := strings.Repeat("x", 1024)
+	for i := 1; i < 100; i++ {
+		writeEntries(t, mapped, fmt.Sprintf(`{"number":%d,"pad":"%s"}`, i, pad))
+	}
+	if _, err := mapped.f.Write([]byte(`{"number":`)); err != nil {
+		t.Fatal(err)
+	}
+	compare()
+	if len(mapped.mm.maps) < 2 {
+		t.Fatalf("expected the file to be mapped again as it grew, got (%d) mappings", len(mapped.mm.maps))
+	}
+}
+
+func TestWithMmapCompact(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("mmap is unsupported")
+	}
+	store := openTemp(t, "mmap.jsonl", WithMmap())
+	for i := 0; i < 10; i++ {
+		writeEntries(t, store, fmt.Sprintf(`{"number":%d}`, i), fmt.Sprintf(`{"number":%d}`, i+1))
+		if _, err := store.ReadBytes(); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := store.ReadBytes(); err != nil || string(b) != fmt.Sprintf(`{"number":%d}`, i+1) {
+			t.Fatalf("got wrong entry from ReadBytes() after Compact(). Expected (%s), got (%s, %v)", fmt.Sprintf(`{"number":%d}`, i+1), b, err)
+		}
+	}
+	// Only the mapping of the current file remains.
+	if len(store.mm.maps) != 1 {
+		t.Fatalf("expected the mappings of replaced files to be released, got (%d) mappings", len(store.mm.maps))
+	}
+}
+
+// collect returns every entry in store.
+func collect(store *Jsonl) ([]string, error) {
+	var entries []string
+	for entry, err := range store.All() {
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, string(entry))
+	}
+	return entries, nil
+}
+
+func TestOpenSharedReadOnly(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "shared.jsonl")
+	writer, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, writer, `{"number":0}`, `{"number":1}`)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		store, err := OpenSharedReadOnly(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		b, err := store.ReadBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != `{"number":1}` {
+			t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":1}`, b)
+		}
+		if _, err := store.Write([]byte(`{"number":2}`)); !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("expected ErrReadOnly from Write(), got (%v)", err)
+		}
+	}
+}
+
+func TestWithMmapTruncating(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mmap.jsonl")
+	for _, opt := range []Option{WithTruncateTrailingGarbage(), WithReadRepair()} {
+		if _, err := OpenFile(filename, WithMmap(), opt); !errors.Is(err, errors.ErrUnsupported) {
+			t.Fatalf("expected ErrUnsupported mapping a store which truncates, got (%v)", err)
+		}
+	}
+}
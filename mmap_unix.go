@@ -0,0 +1,22 @@
+//go:build unix
+
+package jsonl
+
+import (
+	"errors"
+	"syscall"
+)
+
+// mmap maps the first size bytes of f for reading, if it has a file
+// descriptor.
+func mmap(f File, size int64) ([]byte, error) {
+	fd, ok := f.(interface{ Fd() uintptr })
+	if !ok || int64(int(size)) != size {
+		return nil, errors.ErrUnsupported
+	}
+	return syscall.Mmap(int(fd.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
@@ -0,0 +1,554 @@
+package jsonl
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Option configures optional behavior of a *Jsonl. Options are
+// passed to Open() or OpenFile().
+type Option func(*config) error
+
+// config holds the settings applied by Options.
+type config struct {
+	// headerBytes is the size of a fixed header preceding the
+	// jsonl data.
+	headerBytes int64
+	// noAppend opens the file without O_APPEND.
+	noAppend bool
+	// maxEntry overrides entrySizeCap as the maximum entry size.
+	maxEntry int64
+	// validateOnOpen verifies the whole file when it is opened.
+	validateOnOpen bool
+	// readTimeout bounds each read of the file on backends which
+	// support deadlines.
+	readTimeout time.Duration
+	// compactOnClose compacts the file when it is closed.
+	compactOnClose bool
+	// diskFullHook is called when a write fails as the disk is full.
+	diskFullHook func() error
+	// writeHook is called after each entry is durably written.
+	writeHook func(entry []byte, version int)
+	// writeHeader writes a format header to new files.
+	writeHeader bool
+	// truncateGarbage truncates partial writes before appending.
+	truncateGarbage bool
+	// mmap reads the file through a memory mapping.
+	mmap bool
+	// onCorruptTail is called with a partial write found at the end
+	// of the file.
+	onCorruptTail func(corrupt []byte) error
+	// syncTimeout bounds how long Write() waits for a sync.
+	syncTimeout time.Duration
+	// sidecar records entry metadata in a sidecar file.
+	sidecar bool
+	// readRepair truncates partial writes found by reads.
+	readRepair bool
+	// mirror is written with every entry written to the store.
+	mirror *Jsonl
+	// writeTransform is applied to entries before they're written.
+	writeTransform func(entry []byte) ([]byte, error)
+	// readTransform is applied to entries as they're read.
+	readTransform func(entry []byte) ([]byte, error)
+	// log receives warnings, such as when syncing is unsupported.
+	log *slog.Logger
+	// alignment is the multiple of which written entries are padded
+	// to a length.
+	alignment int
+	// closeTimeout bounds how long Close() waits for its final sync
+	// and compaction.
+	closeTimeout time.Duration
+	// readSem bounds the number of concurrent backward scans.
+	readSem chan struct{}
+	// newestFirst stores entries newest first.
+	newestFirst bool
+	// comparator decides whether two entries are equal.
+	comparator func(a, b []byte) bool
+	// readAttempts is the number of times a failed read is tried,
+	// waiting readBackoff after the first failure.
+	readAttempts int
+	readBackoff  time.Duration
+	// metrics receives measurements of the store.
+	metrics Metrics
+	// scratchSize is the initial size of the buffers in which Write()
+	// prepares entries.
+	scratchSize int
+	// rejectNull rejects entries which are not objects or arrays.
+	rejectNull bool
+	// directIO appends whole blocks bypassing the page cache.
+	directIO bool
+	// backpressure is the number of entries a subscriber may lag
+	// behind before writes block, for at most backpressureTimeout.
+	backpressure        int
+	backpressureTimeout time.Duration
+	// truncateOnOpen empties the file when it's opened.
+	truncateOnOpen bool
+	// snapshotReads reads the latest entry without holding j.mu.
+	snapshotReads bool
+}
+
+// openFlag returns the flags used by OpenFile() to open the file.
+func (c *config) openFlag() int {
+	flag := os.O_APPEND | os.O_CREATE | os.O_RDWR
+	if c.noAppend {
+		flag &^= os.O_APPEND
+	}
+	return flag
+}
+
+// maxEntrySize returns the largest entry which may be read or
+// written.
+func (c *config) maxEntrySize() int64 {
+	if c.maxEntry > 0 {
+		return c.maxEntry
+	}
+	return entrySizeCap
+}
+
+// defaultScratchSize is the initial size of the buffers in which
+// Write() prepares entries.
+const defaultScratchSize = 1024
+
+// scratchBufferSize returns the initial size of the buffers in which
+// Write() prepares entries.
+func (c *config) scratchBufferSize() int {
+	if c.scratchSize > 0 {
+		return c.scratchSize
+	}
+	return defaultScratchSize
+}
+
+// WithHeaderBytes treats the first n bytes of the file as a fixed
+// header written by the caller, such as when jsonl data is embedded
+// in a larger file. Reads ignore the header and Write() appends
+// after it as usual.
+func WithHeaderBytes(n int64) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return fmt.Errorf("jsonl: header size must not be negative")
+		}
+		c.headerBytes = n
+		return nil
+	}
+}
+
+// WithoutAppend opens the file without O_APPEND so that it may be
+// overwritten in place with WriteAt(). Write() still appends entries
+// to the end of the file. It is used by stores which replace their
+// contents rather than append to them.
+func WithoutAppend() Option {
+	return func(c *config) error {
+		c.noAppend = true
+		return nil
+	}
+}
+
+// WithMaxMemory caps the memory used to buffer an entry while
+// reading at n bytes, trading the maximum entry size for bounded
+// memory use on constrained devices. Reading or writing an entry
+// larger than n returns ErrEntryTooLarge. Values above the 16M
+// entry size limit have no effect.
+func WithMaxMemory(n int64) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return fmt.Errorf("jsonl: max memory must be positive")
+		}
+		if n < entrySizeCap {
+			c.maxEntry = n
+		}
+		return nil
+	}
+}
+
+// WithOpenValidate verifies the whole file when it is opened, as
+// with Verify(), so that corruption anywhere in the file is detected
+// at startup rather than only at the tail. Opening a corrupt file
+// returns an error wrapping ErrCorrupt.
+func WithOpenValidate() Option {
+	return func(c *config) error {
+		c.validateOnOpen = true
+		return nil
+	}
+}
+
+// WithReadDeadline aborts reads which don't complete within d on
+// backends implementing SetReadDeadline(), such as network-backed
+// files, returning an error wrapping os.ErrDeadlineExceeded. Regular
+// files don't support deadlines, so for them this has no effect.
+func WithReadDeadline(d time.Duration) Option {
+	return func(c *config) error {
+		if d <= 0 {
+			return fmt.Errorf("jsonl: read deadline must be positive")
+		}
+		c.readTimeout = d
+		return nil
+	}
+}
+
+// WithCompactOnClose runs Compact() when the store is closed, so
+// that only the latest entry is persisted. It suits devices which
+// write many revisions during a session but only need the last.
+func WithCompactOnClose() Option {
+	return func(c *config) error {
+		c.compactOnClose = true
+		return nil
+	}
+}
+
+// WithDiskFullHook calls hook when Write() fails because the disk is
+// full, then retries the write once. The hook is expected to free
+// space, such as by compacting or rotating the store, and may use
+// the store to do so. If the retry also fails, or the hook returns
+// an error, Write() returns an error wrapping ErrDiskFull.
+func WithDiskFullHook(hook func() error) Option {
+	return func(c *config) error {
+		c.diskFullHook = hook
+		return nil
+	}
+}
+
+// WithWriteHook calls hook after each entry is durably written,
+// with the compacted entry and its zero-based version, for side
+// effects such as notifications, mirroring or metrics. The hook is
+// called without the store locked, so it may use the store, but it
+// must not retain the entry slice.
+func WithWriteHook(hook func(entry []byte, version int)) Option {
+	return func(c *config) error {
+		c.writeHook = hook
+		return nil
+	}
+}
+
+// WithHeader writes a format header as the first line of a new,
+// empty file, recording how the file was written so that future
+// versions of this package can read it. Files with a format header
+// are detected on open whether or not this option is given, and
+// files without one remain plain JSONL. Opening a non-empty file
+// without a format header with this option returns an error.
+func WithHeader() Option {
+	return func(c *config) error {
+		c.writeHeader = true
+		return nil
+	}
+}
+
+// WithTruncateTrailingGarbage makes Write() truncate a partial write
+// left at the end of the file, such as by a power loss, before
+// appending, rather than demarcating it with a newline. This keeps
+// the file free of corrupt entries. It has no effect on backends
+// which don't support Truncate().
+func WithTruncateTrailingGarbage() Option {
+	return func(c *config) error {
+		c.truncateGarbage = true
+		return nil
+	}
+}
+
+// WithMmap reads the file through a read-only memory mapping rather
+// than with ReadAt(), avoiding a syscall per chunk when scanning
+// large files. The file is mapped again as it grows, and mappings are
+// released by Close(). It falls back to ReadAt() on platforms and
+// backends which can't be mapped. As with any mapping, the file must
+// not be truncated by another process while the store is open, so it
+// can't be combined with WithTruncateTrailingGarbage() or
+// WithReadRepair().
+func WithMmap() Option {
+	return func(c *config) error {
+		c.mmap = true
+		return nil
+	}
+}
+
+// WithOnCorruptTail calls fn with the bytes of a partial write found
+// at the end of the file by Open() and by reads of the latest entry,
+// such as Read() and Decode(). If fn returns an error the operation
+// fails with it, otherwise the partial write is skipped as usual. At
+// most the maximum entry size is passed to fn. The store is locked
+// while fn runs, so it must not use the store.
+func WithOnCorruptTail(fn func(corrupt []byte) error) Option {
+	return func(c *config) error {
+		c.onCorruptTail = fn
+		return nil
+	}
+}
+
+// WithSyncTimeout bounds how long Write() waits for the file to sync,
+// as a failing storage device may block a sync for a long time. If
+// the sync takes longer than d, Write() returns an error wrapping
+// ErrSyncTimeout while the sync continues in the background. The
+// entry is then not guaranteed to be durable.
+func WithSyncTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		c.syncTimeout = d
+		return nil
+	}
+}
+
+// WithSidecar records metadata for each entry written, such as when
+// it was written, in a sidecar file beside the store, leaving the
+// entries themselves untouched. For "store.jsonl" the sidecar is
+// "store.meta.jsonl". It is kept in step with compaction and other
+// rewrites, and is read with Meta(). It requires a named file.
+func WithSidecar() Option {
+	return func(c *config) error {
+		c.sidecar = true
+		return nil
+	}
+}
+
+// WithReadRepair makes Read() and ReadBytes() truncate a partial
+// write left at the end of the file, such as by a power loss, after
+// reading past it. Reads otherwise leave the file untouched. It has
+// no effect on backends which don't support Truncate().
+func WithReadRepair() Option {
+	return func(c *config) error {
+		c.readRepair = true
+		return nil
+	}
+}
+
+// WithMirror writes every entry written to the store to mirror too,
+// such as a backup store on another disk. The entry is written to
+// the store first, and if writing it to mirror then fails Write()
+// returns an error wrapping ErrMirror. Otherwise an error is from the
+// store, and mirror is not written. The mirror must not itself be
+// mirrored to the store.
+func WithMirror(mirror *Jsonl) Option {
+	return func(c *config) error {
+		c.mirror = mirror
+		return nil
+	}
+}
+
+// WithWriteTransform applies fn to each entry passed to Write(),
+// after it's validated and before it's compacted, storing the JSON
+// which fn returns in its place. This enforces a canonical form, such
+// as by adding defaults or removing volatile fields. An error from fn
+// aborts the write, as does a result which is not valid JSON.
+func WithWriteTransform(fn func(entry []byte) ([]byte, error)) Option {
+	return func(c *config) error {
+		c.writeTransform = fn
+		return nil
+	}
+}
+
+// WithReadTransform applies fn to each entry read from the store, by
+// Read(), All(), the typed readers, watchers and so on, such as to
+// redact fields or migrate an old schema, leaving the file untouched.
+// Maintenance such as Compact() and counting see entries as stored.
+// An error from fn fails the read. The entry passed to fn may be a
+// read buffer, so fn must not retain it. The store may be locked
+// while fn runs, so it must not use the store.
+func WithReadTransform(fn func(entry []byte) ([]byte, error)) Option {
+	return func(c *config) error {
+		c.readTransform = fn
+		return nil
+	}
+}
+
+// WithLogger logs warnings which don't fail an operation to l, such
+// as a backend which doesn't support syncing. They are discarded by
+// default.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) error {
+		c.log = l
+		return nil
+	}
+}
+
+// WithWriteAlignment pads each entry written with spaces before its
+// newline so that its length is a multiple of n bytes, such as the
+// erase block size of a flash device, to reduce wear. Entries stay
+// aligned to n provided the file begins aligned. Readers ignore the
+// padding.
+func WithWriteAlignment(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return fmt.Errorf("jsonl: write alignment must be positive")
+		}
+		c.alignment = n
+		return nil
+	}
+}
+
+// WithCloseTimeout syncs the file when the store is closed, along with
+// any WithCompactOnClose() compaction, and bounds how long Close()
+// waits for them, as failing storage may block them indefinitely. If
+// they take longer than d, Close() releases the file and returns an
+// error wrapping ErrCloseTimeout. An abandoned compaction fails rather
+// than replacing the file.
+func WithCloseTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		if d <= 0 {
+			return fmt.Errorf("jsonl: close timeout must be positive")
+		}
+		c.closeTimeout = d
+		return nil
+	}
+}
+
+// WithReadConcurrency allows at most n backward scans of the file,
+// such as those made by Read() and Decode(), to run at once, with
+// others waiting their turn. Each scan buffers up to an entry, so
+// this bounds the memory and IO spent when many goroutines read a
+// large or corrupt file at once, as on constrained devices.
+// Functions such as ReadIntoPooled() callbacks run during a scan, so
+// must not read the store themselves.
+func WithReadConcurrency(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return fmt.Errorf("jsonl: read concurrency must be positive")
+		}
+		c.readSem = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// WithNewestFirst stores entries newest first, so that consumers which
+// read the first line of the file see the latest entry. Read(),
+// Decode() and the like return the first entry, while All(),
+// Entries() and other iteration follow the file, newest first. As
+// files can't be prepended to, every write rewrites the whole file as
+// Compact() does, which costs time and wear in proportion to its
+// size, so the store should be kept small, such as with Prune().
+// Writes require a named file, and WithSidecar() is not supported.
+func WithNewestFirst() Option {
+	return func(c *config) error {
+		c.newestFirst = true
+		return nil
+	}
+}
+
+// WithComparator decides whether two entries are equal with fn for
+// CompareAndWrite() and AppendIfChanged(), rather than comparing them
+// canonically, such as to ignore a volatile timestamp field. fn is
+// called with the store locked, so it must not use the store, and
+// must not retain its arguments.
+func WithComparator(fn func(a, b []byte) bool) Option {
+	return func(c *config) error {
+		c.comparator = fn
+		return nil
+	}
+}
+
+// WithReadRetry tries each failed read of the file up to attempts
+// times, as flaky storage may fail a read with a transient error
+// such as EIO which then succeeds. It waits backoff after the first
+// failure, doubling the wait after each further failure. Reaching the
+// end of the file, and reads of a closed file or past the read
+// deadline, are not retried.
+func WithReadRetry(attempts int, backoff time.Duration) Option {
+	return func(c *config) error {
+		if attempts <= 0 {
+			return fmt.Errorf("jsonl: read attempts must be positive")
+		}
+		if backoff < 0 {
+			return fmt.Errorf("jsonl: read backoff must not be negative")
+		}
+		c.readAttempts, c.readBackoff = attempts, backoff
+		return nil
+	}
+}
+
+// WithMetrics reports measurements of the store to m, such as the
+// size of each entry written, for capacity planning.
+func WithMetrics(m Metrics) Option {
+	return func(c *config) error {
+		c.metrics = m
+		return nil
+	}
+}
+
+// WithScratchBufferSize sizes the buffers which Write() reuses to
+// prepare entries to n bytes, which should be the size of a typical
+// entry. Larger entries grow the buffers as needed, and buffers of
+// up to n bytes, or 64KiB if larger, are kept for reuse, so that
+// writing entries of up to that size doesn't allocate a buffer.
+func WithScratchBufferSize(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return fmt.Errorf("jsonl: scratch buffer size must be positive")
+		}
+		c.scratchSize = n
+		return nil
+	}
+}
+
+// WithRejectNull makes writes reject entries which are not JSON
+// objects or arrays with an error wrapping ErrNotJSON. By default
+// any JSON value is an entry, including null, which Decode() into a
+// pointer sets to nil, as well as booleans, numbers and strings,
+// which are rarely intended by callers storing records.
+func WithRejectNull() Option {
+	return func(c *config) error {
+		c.rejectNull = true
+		return nil
+	}
+}
+
+// WithDirectIO appends entries to files opened by OpenFile() or
+// CreateFile() with direct IO, bypassing the page cache, for devices
+// where the cache competes with applications for memory. Direct IO
+// requires writes of whole blocks at aligned offsets, so entries are
+// padded to 4KiB as by WithWriteAlignment(), which costs space for
+// small entries. Writes which can't be aligned, such as to a file
+// with a header, go through the page cache, as do all writes on
+// platforms and filesystems which don't support direct IO, which is
+// logged as a warning. Reads always use the page cache.
+func WithDirectIO() Option {
+	return func(c *config) error {
+		c.directIO = true
+		c.alignment = directBlock
+		return nil
+	}
+}
+
+// WithBackpressure makes writes wait while any WatchLatest() or
+// StreamTo() subscriber has depth or more entries it has yet to
+// receive, so that a slow subscriber paces the writers rather than
+// falling ever further behind. Concurrent writes may each pass the
+// check before any of them lands, overshooting depth by the number of
+// writers. If the subscriber hasn't caught up within timeout, such as
+// because it has stopped receiving, the write fails with
+// ErrBackpressure and nothing is written.
+func WithBackpressure(depth int, timeout time.Duration) Option {
+	return func(c *config) error {
+		if depth <= 0 {
+			return fmt.Errorf("jsonl: backpressure depth must be positive")
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("jsonl: backpressure timeout must be positive")
+		}
+		c.backpressure, c.backpressureTimeout = depth, timeout
+		return nil
+	}
+}
+
+// WithTruncateOnOpen makes OpenFile() empty an existing file once
+// it's opened, under the file lock, so that each run starts with a
+// clean store at a stable path, such as for ephemeral session state.
+// A WithHeaderBytes() header is kept, a format header is written
+// afresh, and a sidecar is emptied along with the store. Stores passed to Open() are not truncated.
+func WithTruncateOnOpen() Option {
+	return func(c *config) error {
+		c.truncateOnOpen = true
+		return nil
+	}
+}
+
+// WithSnapshotReads makes Read(), ReadBytes(), Latest() and Decode()
+// hold the store's lock only while finding the size of the file, then
+// read the latest entry within that size without it, so that a slow
+// read, such as a backward scan past a large entry, doesn't hold up
+// writes. The file up to that size doesn't change as entries are
+// appended, so the read returns the entry which was latest when it
+// began. Rewrites, such as by Compact(), still wait for these reads
+// to finish before releasing the file they read from.
+func WithSnapshotReads() Option {
+	return func(c *config) error {
+		c.snapshotReads = true
+		return nil
+	}
+}
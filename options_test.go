@@ -0,0 +1,895 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithHeaderBytes(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "header.jsonl")
+	// A 16-byte header which is neither JSON nor newline-terminated.
+	header := []byte("MAGIC\n{\"v\":1}\nXX")
+	if len(header) != 16 {
+		t.Fatalf("test header must be 16 bytes, got (%d)", len(header))
+	}
+	if err := os.WriteFile(filename, header, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenFile(filename, WithHeaderBytes(int64(len(header))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"number":1}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":1}`, latest)
+	}
+	count, err := store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected Count() of (%d), got (%d)", 2, count)
+	}
+	var first []byte
+	for entry, err := range store.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		first = entry
+		break
+	}
+	if string(first) != `{"number":0}` {
+		t.Fatalf("got wrong first entry from All(). Expected (%s), got (%s)", `{"number":0}`, first)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:len(header)]) != string(header) {
+		t.Fatalf("header was modified: %q", b[:len(header)])
+	}
+}
+
+func TestWithoutAppend(t *testing.T) {
+	appending := openTemp(t, "append.jsonl")
+	writeEntries(t, appending, `{"number":0}`)
+	if _, err := appending.f.WriteAt([]byte(`{"number":9}`), 0); err == nil {
+		t.Fatal("expected WriteAt() to fail on a file opened with O_APPEND")
+	}
+
+	store := openTemp(t, "noappend.jsonl", WithoutAppend())
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	// Overwrite the first entry in place.
+	if _, err := store.f.WriteAt([]byte(`{"number":9}`), 0); err != nil {
+		t.Fatal(err)
+	}
+	// Write() must still append after the existing entries.
+	writeEntries(t, store, `{"number":2}`)
+	var got []string
+	for entry, err := range store.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(entry))
+	}
+	want := []string{`{"number":9}`, `{"number":1}`, `{"number":2}`}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got wrong entries. Expected (%v), got (%v)", want, got)
+	}
+}
+
+func TestWithMaxMemory(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "maxmemory.jsonl")
+	small := `{"number":0}`
+	large := `{"pad":"` + strings.Repeat("x", 8192) + `"}`
+
+	unbounded, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, unbounded, small, large)
+	if err := unbounded.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFile(filename, WithMaxMemory(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.ReadBytes(); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected ErrEntryTooLarge reading a large entry, got (%v)", err)
+	}
+	if _, err := store.Count(); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected ErrEntryTooLarge counting a large entry, got (%v)", err)
+	}
+	if _, err := store.Write([]byte(large)); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected ErrEntryTooLarge writing a large entry, got (%v)", err)
+	}
+
+	writeEntries(t, store, small)
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != small {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", small, latest)
+	}
+}
+
+func TestWithMaxMemoryBuffer(t *testing.T) {
+	const limit = 1 << 20
+	store := openTemp(t, "maxmemory.jsonl", WithMaxMemory(limit))
+	writeEntries(t, store, `{"pad":"`+strings.Repeat("x", limit-16)+`"}`)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := store.ReadIntoPooled(func([]byte) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	// The buffer grows geometrically, allocating the limit and about
+	// a third more again for the smaller buffers before it, but the
+	// last growth must not double past the limit.
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 3*limit {
+		t.Fatalf("expected reading an entry to allocate under (%d) bytes, allocated (%d)", 3*limit, allocated)
+	}
+}
+
+func TestWithOpenValidate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "validate.jsonl")
+	data := "{\"number\":0}\n\n{\"number\":1}\n{\"number\":\n{\"number\":3}\n{\"number\":"
+	if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Without validation the mid-file corruption goes unnoticed.
+	store, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenFile(filename, WithOpenValidate())
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt opening a corrupt file, got (%v)", err)
+	}
+	if !strings.Contains(err.Error(), "line 4") {
+		t.Fatalf("expected the error to report line 4, got (%v)", err)
+	}
+
+	// A partial write at the tail is recoverable, and not corruption.
+	clean := filepath.Join(t.TempDir(), "clean.jsonl")
+	if err := os.WriteFile(clean, []byte("{\"number\":0}\n{\"number\":"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err = OpenFile(clean, WithOpenValidate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+}
+
+func TestWithWriteHook(t *testing.T) {
+	type call struct {
+		entry   string
+		version int
+	}
+	var calls []call
+	var store *Jsonl
+	store = openTemp(t, "hook.jsonl", WithWriteHook(func(entry []byte, version int) {
+		calls = append(calls, call{string(entry), version})
+		// The hook may re-enter the store.
+		if _, err := store.Count(); err != nil {
+			t.Error(err)
+		}
+	}))
+	writeEntries(t, store, `{"number":0}`, "{ \"number\": 1 }")
+	if _, err := store.WriteLines([]byte("{\"number\":2}\n{\"number\":3}\n")); err != nil {
+		t.Fatal(err)
+	}
+	want := []call{
+		{`{"number":0}`, 0},
+		{`{"number":1}`, 1},
+		{`{"number":2}`, 2},
+		{`{"number":3}`, 3},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("expected (%d) hook calls, got (%+v)", len(want), calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("got wrong hook call (%d). Expected (%+v), got (%+v)", i, want[i], calls[i])
+		}
+	}
+	if _, err := store.Write([]byte(`{"number":`)); err == nil {
+		t.Fatal("expected an error writing invalid JSON")
+	}
+	if len(calls) != len(want) {
+		t.Fatal("expected no hook call for a failed write")
+	}
+
+	// A partial write completed by the newline injected before the
+	// next entry counts towards its version.
+	if _, err := store.f.Write([]byte(`{"number":4}`)); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":5}`)
+	if got, want := calls[len(calls)-1], (call{`{"number":5}`, 5}); got != want {
+		t.Fatalf("got wrong hook call after a partial write. Expected (%+v), got (%+v)", want, got)
+	}
+}
+
+func TestWithTruncateTrailingGarbage(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "truncate.jsonl")
+	store, err := OpenFile(filename, WithTruncateTrailingGarbage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	writeEntries(t, store, `{"number":0}`)
+	if _, err := store.f.Write([]byte(`{"number":1,"pad`)); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":2}`)
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"number\":0}\n{\"number\":2}\n" {
+		t.Fatalf("expected the partial write to be truncated, got (%q)", b)
+	}
+
+	// With no complete entries, the whole file is garbage.
+	if err := os.WriteFile(filename, []byte(`{"num`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":3}`)
+	if b, err = os.ReadFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"number\":3}\n" {
+		t.Fatalf("expected the partial write to be truncated, got (%q)", b)
+	}
+}
+
+func TestWithOnCorruptTail(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tail.jsonl")
+	if err := os.WriteFile(filename, []byte("{\"number\":0}\n{\"number\":1,\"pa"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	fail := false
+	errTail := errors.New("corrupt tail")
+	store, err := OpenFile(filename, WithOnCorruptTail(func(corrupt []byte) error {
+		got = append(got, string(corrupt))
+		if fail {
+			return errTail
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if len(got) != 1 || got[0] != `{"number":1,"pa` {
+		t.Fatalf("expected the corrupt tail to be passed on Open(), got (%q)", got)
+	}
+	if b, err := store.ReadBytes(); err != nil || string(b) != `{"number":0}` {
+		t.Fatalf("expected reads to proceed past the corrupt tail, got (%s, %v)", b, err)
+	}
+	fail = true
+	if _, err := store.ReadBytes(); !errors.Is(err, errTail) {
+		t.Fatalf("expected the callback error from ReadBytes(), got (%v)", err)
+	}
+	var v struct{ Number int }
+	if err := store.Decode(&v); !errors.Is(err, errTail) {
+		t.Fatalf("expected the callback error from Decode(), got (%v)", err)
+	}
+	if _, err := OpenFile(filename, WithOnCorruptTail(func([]byte) error { return errTail })); !errors.Is(err, errTail) {
+		t.Fatalf("expected the callback error from Open(), got (%v)", err)
+	}
+
+	// A write ends the partial write, so the callback is not called.
+	fail = false
+	writeEntries(t, store, `{"number":2}`)
+	got = nil
+	if _, err := store.ReadBytes(); err != nil || got != nil {
+		t.Fatalf("expected no callback without a corrupt tail, got (%q, %v)", got, err)
+	}
+}
+
+func TestWithReadRepair(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "repair.jsonl")
+	contents := "{\"number\":0}\n{\"number\":1}\n"
+	if err := os.WriteFile(filename, []byte(contents+`{"number":2,"pa`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenFile(filename, WithReadRepair())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	b, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"number":1}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":1}`, b)
+	}
+	if b, err = os.ReadFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != contents {
+		t.Fatalf("expected the partial write to be truncated, got (%q)", b)
+	}
+}
+
+func TestWithMirror(t *testing.T) {
+	mirror := openTemp(t, "mirror.jsonl")
+	store := openTemp(t, "primary.jsonl", WithMirror(mirror))
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	if _, err := store.WriteLines([]byte("{\"number\":2}\n{\"number\":3}\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Jsonl{store, mirror} {
+		entries, err := s.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 4 || string(entries[3]) != `{"number":3}` {
+			t.Fatalf("expected both stores to hold every entry, got (%q)", entries)
+		}
+	}
+	if err := mirror.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Write([]byte(`{"number":4}`)); !errors.Is(err, ErrMirror) {
+		t.Fatalf("expected ErrMirror writing to a closed mirror, got (%v)", err)
+	}
+	if b, _ := store.ReadBytes(); string(b) != `{"number":4}` {
+		t.Fatalf("expected the entry to be written to the store, got (%s)", b)
+	}
+}
+
+func TestWithWriteTransform(t *testing.T) {
+	errNotObject := errors.New("not an object")
+	store := openTemp(t, "transform.jsonl", WithWriteTransform(func(entry []byte) ([]byte, error) {
+		var m map[string]interface{}
+		if err := json.Unmarshal(entry, &m); err != nil {
+			return nil, errNotObject
+		}
+		m["version"] = 1
+		return json.Marshal(m)
+	}))
+	input := []byte(`{"number":0}`)
+	if _, err := store.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.WriteLines([]byte("{\"number\":1}\n")); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range entries {
+		if want := fmt.Sprintf(`{"number":%d,"version":1}`, i); string(entry) != want {
+			t.Fatalf("got wrong stored entry. Expected (%s), got (%s)", want, entry)
+		}
+	}
+	if string(input) != `{"number":0}` {
+		t.Fatal("expected the caller's input to be untouched")
+	}
+	if _, err := store.Write([]byte(`[0]`)); !errors.Is(err, errNotObject) {
+		t.Fatalf("expected the transform error to abort the write, got (%v)", err)
+	}
+	if n, _ := store.Count(); n != 2 {
+		t.Fatalf("expected (2) entries after an aborted write, got (%d)", n)
+	}
+}
+
+func TestWithReadTransform(t *testing.T) {
+	redact := func(entry []byte) ([]byte, error) {
+		var m map[string]interface{}
+		if err := json.Unmarshal(entry, &m); err != nil {
+			return nil, err
+		}
+		delete(m, "secret")
+		return json.Marshal(m)
+	}
+	filename := filepath.Join(t.TempDir(), "redact.jsonl")
+	store, err := OpenFile(filename, WithReadTransform(redact))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	writeEntries(t, store, `{"number":0,"secret":"a"}`, `{"number":1,"secret":"b"}`)
+	var v map[string]interface{}
+	if err := store.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v["secret"]; ok || v["number"] != 1.0 {
+		t.Fatalf("expected the secret to be removed from Decode(), got (%v)", v)
+	}
+	if b, _ := store.ReadBytes(); string(b) != `{"number":1}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":1}`, b)
+	}
+	entries, err := collect(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(entries, "\n") != "{\"number\":0}\n{\"number\":1}" {
+		t.Fatalf("expected the secrets to be removed from All(), got (%q)", entries)
+	}
+	last, err := LastN[map[string]interface{}](store, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range last {
+		if _, ok := v["secret"]; ok {
+			t.Fatalf("expected the secrets to be removed from LastN(), got (%v)", last)
+		}
+	}
+	if err := store.DecodeOldest(&v); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v["secret"]; ok {
+		t.Fatalf("expected the secret to be removed from DecodeOldest(), got (%v)", v)
+	}
+	if e, _, err := store.ReadAtOffset(0); err != nil || string(e) != `{"number":0}` {
+		t.Fatalf("got wrong entry from ReadAtOffset(). Expected (%s), got (%s, %v)", `{"number":0}`, e, err)
+	}
+	// Rewrites keep the entries as stored.
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"secret":"b"`) {
+		t.Fatalf("expected the secret to remain on disk, got (%q)", b)
+	}
+}
+
+func TestWithWriteAlignment(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "aligned.jsonl")
+	store, err := OpenFile(filename, WithWriteAlignment(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	writeEntries(t, store, `{"number":0}`, `{"number":1,"name":"one"}`, `{"pad":"0123456789abc"}`)
+	if _, err := store.WriteLines([]byte("{\"number\":3}\n")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	lines = lines[:len(lines)-1]
+	if len(lines) != 4 {
+		t.Fatalf("expected (4) lines, got (%q)", lines)
+	}
+	for i, line := range lines {
+		if len(line)%16 != 0 {
+			t.Fatalf("expected line (%d) to be a multiple of (16) bytes, got (%d)", i, len(line))
+		}
+	}
+	var v struct {
+		Number int `json:"number"`
+	}
+	if err := store.Decode(&v); err != nil || v.Number != 3 {
+		t.Fatalf("expected padded entries to decode, got (%+v, %v)", v, err)
+	}
+	if latest, _ := store.ReadBytes(); string(latest) != `{"number":3}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s)", `{"number":3}`, latest)
+	}
+}
+
+// inflightFile is a File which records the most reads in flight at
+// once.
+type inflightFile struct {
+	*os.File
+	mu       sync.Mutex
+	inflight int
+	max      int
+}
+
+func (f *inflightFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	f.inflight++
+	f.max = max(f.max, f.inflight)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inflight--
+		f.mu.Unlock()
+	}()
+	time.Sleep(time.Millisecond)
+	return f.File.ReadAt(p, off)
+}
+
+func TestWithReadConcurrency(t *testing.T) {
+	plain := openTemp(t, "concurrency.jsonl")
+	writeEntries(t, plain, `{"number":0}`, `{"number":1}`)
+	backend := &inflightFile{File: plain.f.(*os.File)}
+	store, err := Open(backend, WithReadConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b, err := store.ReadBytes(); err != nil || string(b) != `{"number":1}` {
+				t.Errorf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", `{"number":1}`, b, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if backend.max > 2 {
+		t.Fatalf("expected at most (%d) reads in flight, got (%d)", 2, backend.max)
+	}
+	if _, err := OpenFile(filepath.Join(t.TempDir(), "invalid.jsonl"), WithReadConcurrency(0)); err == nil {
+		t.Fatal("expected an error from WithReadConcurrency(0)")
+	}
+}
+
+func TestWithNewestFirst(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "newest.jsonl")
+	store, err := OpenFile(filename, WithNewestFirst())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := store.Write([]byte(fmt.Sprintf(`{"number":%d}`, i))); err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf(`{"number":%d}`, i)
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first, _, _ := strings.Cut(string(b), "\n"); first != want {
+			t.Fatalf("got wrong first line. Expected (%s), got (%s)", want, first)
+		}
+		if b, err := store.ReadBytes(); err != nil || string(b) != want {
+			t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", want, b, err)
+		}
+	}
+	if _, err := store.WriteLines([]byte("{\"number\":3}\n{\"number\":4}\n")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := collect(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"number":4},{"number":3},{"number":2},{"number":1},{"number":0}`
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got wrong entries from All(). Expected (%s), got (%s)", want, strings.Join(got, ","))
+	}
+	var v struct{ Number int }
+	if err := store.DecodeOldest(&v); err != nil || v.Number != 0 {
+		t.Fatalf("got wrong entry from DecodeOldest(). Expected (%d), got (%d, %v)", 0, v.Number, err)
+	}
+	if err := store.Decode(&v); err != nil || v.Number != 4 {
+		t.Fatalf("got wrong entry from Decode(). Expected (%d), got (%d, %v)", 4, v.Number, err)
+	}
+}
+
+// sizeRecorder is a Metrics which records the write sizes observed.
+type sizeRecorder struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (r *sizeRecorder) ObserveWriteSize(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sizes = append(r.sizes, n)
+}
+
+func TestWithNewestFirstLatest(t *testing.T) {
+	type Entry struct {
+		N int `json:"n"`
+	}
+	filename := filepath.Join(t.TempDir(), "newest.jsonl")
+	store, err := OpenFile(filename, WithNewestFirst())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	writeEntries(t, store, `{"n":1}`, `{"n":2}`, `{"n":3}`)
+
+	last, err := LastN[Entry](store, 2)
+	if err != nil || len(last) != 2 || last[0].N != 2 || last[1].N != 3 {
+		t.Fatalf("got wrong entries from LastN(). Expected ([{2} {3}]), got (%v, %v)", last, err)
+	}
+	latestN, err := DecodeLatestN[Entry](store, 2)
+	if err != nil || len(latestN) != 2 || latestN[0].N != 3 || latestN[1].N != 2 {
+		t.Fatalf("got wrong entries from DecodeLatestN(). Expected ([{3} {2}]), got (%v, %v)", latestN, err)
+	}
+	if v, err := LatestDecoded[Entry](store); err != nil || v.N != 3 {
+		t.Fatalf("got wrong entry from LatestDecoded(). Expected (%d), got (%d, %v)", 3, v.N, err)
+	}
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := snap.Latest(); err != nil || string(b) != `{"n":3}` {
+		t.Fatalf("got wrong entry from Snapshot.Latest(). Expected (%s), got (%s, %v)", `{"n":3}`, b, err)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reclaimable, err := store.ReclaimableBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"n\":3}\n" {
+		t.Fatalf("expected Compact() to keep the latest entry, got (%q)", b)
+	}
+	if got := stat.Size() - int64(len(b)); got != reclaimable {
+		t.Fatalf("got wrong estimate from ReclaimableBytes(). Compact() freed (%d), estimated (%d)", got, reclaimable)
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	metrics := &sizeRecorder{}
+	store, err := OpenFile(filepath.Join(t.TempDir(), "metrics.jsonl"), WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var want []int
+	for _, entry := range []string{`{"number":0}`, `{"text":"a longer entry"}`, `[]`} {
+		n, err := store.Write([]byte(entry))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, n)
+	}
+	if _, err := store.WriteLines([]byte("{\"a\":1}\n{\"bb\":22}\n")); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, len("{\"a\":1}\n"), len("{\"bb\":22}\n"))
+	if fmt.Sprint(metrics.sizes) != fmt.Sprint(want) {
+		t.Fatalf("got wrong write sizes. Expected (%v), got (%v)", want, metrics.sizes)
+	}
+
+	// A panic is recovered, and reported after the entry is written.
+	panicky, err := OpenFile(filepath.Join(t.TempDir(), "panic.jsonl"), WithMetrics(panicMetrics{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer panicky.Close()
+	if _, err := panicky.Write([]byte(`{"number":0}`)); !errors.Is(err, ErrPanic) {
+		t.Fatalf("expected ErrPanic from a panicking Metrics, got (%v)", err)
+	}
+	if b, err := panicky.ReadBytes(); err != nil || string(b) != `{"number":0}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", `{"number":0}`, b, err)
+	}
+}
+
+// panicMetrics is a Metrics which panics.
+type panicMetrics struct{}
+
+func (panicMetrics) ObserveWriteSize(int) {
+	panic("observe")
+}
+
+func TestWithScratchBufferSize(t *testing.T) {
+	entry := benchEntry(256 * 1024)
+	// allocated returns the bytes allocated per Write() once the
+	// buffers are warm.
+	allocated := func(opts ...Option) uint64 {
+		store, err := OpenFile(filepath.Join(t.TempDir(), "scratch.jsonl"), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		if _, err := store.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+		const writes = 10
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for i := 0; i < writes; i++ {
+			if _, err := store.Write(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		runtime.ReadMemStats(&after)
+		return (after.TotalAlloc - before.TotalAlloc) / writes
+	}
+	// Without a sized buffer, large entries aren't kept for reuse, so
+	// sizing it saves allocating a buffer for every write. Other
+	// allocations, such as by encoding/json, are counted in both.
+	unsized, sized := allocated(), allocated(WithScratchBufferSize(len(entry)+1))
+	if sized+uint64(len(entry)) > unsized {
+		t.Fatalf("expected a sized buffer to save (%d) bytes per write, allocated (%d) bytes rather than (%d)", len(entry), sized, unsized)
+	}
+	if _, err := OpenFile(filepath.Join(t.TempDir(), "invalid.jsonl"), WithScratchBufferSize(0)); err == nil {
+		t.Fatal("expected an error from WithScratchBufferSize(0)")
+	}
+}
+
+func TestWithRejectNull(t *testing.T) {
+	// By default, null is an entry.
+	store := openTemp(t, "null.jsonl")
+	writeEntries(t, store, `{"number":0}`, `null`)
+	if b, err := store.ReadBytes(); err != nil || string(b) != "null" {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (null), got (%s, %v)", b, err)
+	}
+	v := &struct{ Number int }{}
+	if err := store.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v != nil {
+		t.Fatalf("expected Decode() of null to set the pointer to nil, got (%v)", v)
+	}
+
+	store, err := OpenFile(filepath.Join(t.TempDir(), "rejectnull.jsonl"), WithRejectNull())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, entry := range []string{`null`, `true`, `false`, `1.5`, `"text"`} {
+		if _, err := store.Write([]byte(entry)); !errors.Is(err, ErrNotJSON) {
+			t.Fatalf("expected ErrNotJSON writing (%s), got (%v)", entry, err)
+		}
+	}
+	if _, err := store.WriteLines([]byte("{}\nnull\n")); !errors.Is(err, ErrNotJSON) || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected ErrNotJSON reporting line 2, got (%v)", err)
+	}
+	for _, entry := range []string{`{"number":0}`, ` [1,2]`} {
+		if _, err := store.Write([]byte(entry)); err != nil {
+			t.Fatalf("expected (%s) to be written, got (%v)", entry, err)
+		}
+	}
+}
+
+func TestWithTruncateOnOpen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(filename, []byte("{\"number\":0}\n{\"number\":1}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenFile(filename, WithTruncateOnOpen())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Latest(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty from a truncated store, got (%v)", err)
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != 0 {
+		t.Fatalf("expected an empty file, got (%d) bytes", stat.Size())
+	}
+	writeEntries(t, store, `{"number":2}`)
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"number\":2}\n" {
+		t.Fatalf("got wrong file contents. Expected (%q), got (%q)", "{\"number\":2}\n", b)
+	}
+
+	// A fixed header is kept and a format header after it written afresh.
+	const fixed, format = "HDR\n", `{"$jsonl":{"version":1}}` + "\n"
+	headed := filepath.Join(t.TempDir(), "headed.jsonl")
+	if err := os.WriteFile(headed, []byte(fixed+format+"{\"number\":0}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err = OpenFile(headed, WithHeaderBytes(int64(len(fixed))), WithTruncateOnOpen())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Latest(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty from a truncated store, got (%v)", err)
+	}
+	writeEntries(t, store, `{"number":1}`)
+	if b, err = os.ReadFile(headed); err != nil {
+		t.Fatal(err)
+	}
+	if want := fixed + format + "{\"number\":1}\n"; string(b) != want {
+		t.Fatalf("got wrong file contents. Expected (%q), got (%q)", want, b)
+	}
+}
+
+// gatedFile is a File whose next read once armed signals reading,
+// then blocks until gate is closed.
+type gatedFile struct {
+	*os.File
+	armed   atomic.Bool
+	reading chan struct{}
+	gate    chan struct{}
+}
+
+func (f *gatedFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.armed.CompareAndSwap(true, false) {
+		close(f.reading)
+		<-f.gate
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func TestWithSnapshotReads(t *testing.T) {
+	plain := openTemp(t, "snapshot.jsonl")
+	writeEntries(t, plain, `{"number":0}`)
+	backend := &gatedFile{File: plain.f.(*os.File), reading: make(chan struct{}), gate: make(chan struct{})}
+	store, err := Open(backend, WithSnapshotReads())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.armed.Store(true)
+	type result struct {
+		entry []byte
+		err   error
+	}
+	read := make(chan result, 1)
+	go func() {
+		b, err := store.ReadBytes()
+		read <- result{b, err}
+	}()
+	<-backend.reading
+	// The read is stalled, but doesn't hold up the writer.
+	written := make(chan error, 1)
+	go func() {
+		_, err := store.Write([]byte(`{"number":1}`))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write blocked behind a read")
+	}
+	close(backend.gate)
+	// The read returns the entry which was latest when it began.
+	if r := <-read; r.err != nil || string(r.entry) != `{"number":0}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", `{"number":0}`, r.entry, r.err)
+	}
+	if b, err := store.ReadBytes(); err != nil || string(b) != `{"number":1}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", `{"number":1}`, b, err)
+	}
+}
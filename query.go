@@ -0,0 +1,110 @@
+package jsonl
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// DistinctValues returns the distinct values of the top-level field
+// across all entries, in the order they were first seen. Entries
+// which are not JSON objects or which lack the field are skipped.
+func (j *Jsonl) DistinctValues(field string) ([]json.RawMessage, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	var values []json.RawMessage
+	seen := make(map[string]struct{})
+	err := j.entries("DistinctValues", func(_ int64, entry []byte) error {
+		v, ok := lookup(entry, field)
+		if !ok {
+			return nil
+		}
+		key := string(v)
+		if _, ok := seen[key]; ok {
+			return nil
+		}
+		seen[key] = struct{}{}
+		values = append(values, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// IndexOf returns the zero-based index of the oldest entry for
+// which match returns true, or -1 if there is none. An error
+// returned by match ends the search and is returned.
+func (j *Jsonl) IndexOf(match func(entry []byte) (bool, error)) (int, error) {
+	view, release, err := j.snapshot("IndexOf")
+	if err != nil {
+		return -1, err
+	}
+	defer release()
+	i, found := 0, -1
+	err = view.entries(func(_ int64, entry []byte) error {
+		var ok bool
+		err := call(func() (err error) {
+			ok, err = match(entry)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if ok {
+			found = i
+			return errStop
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	return found, nil
+}
+
+// lookup returns the compacted value of the top-level field of an
+// entry, and whether the entry is an object containing the field.
+func lookup(entry []byte, field string) (json.RawMessage, bool) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(entry, &obj); err != nil {
+		return nil, false
+	}
+	v, ok := obj[field]
+	if !ok {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, v); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// CountWhere returns the number of non-corrupt entries for which
+// match returns true, without holding them in memory. An error
+// returned by match ends the scan and is returned.
+func (j *Jsonl) CountWhere(match func(entry []byte) (bool, error)) (int, error) {
+	view, release, err := j.snapshot("CountWhere")
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	count := 0
+	err = view.entries(func(_ int64, entry []byte) error {
+		var ok bool
+		err := call(func() (err error) {
+			ok, err = match(entry)
+			return err
+		})
+		if ok {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
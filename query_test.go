@@ -0,0 +1,91 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDistinctValues(t *testing.T) {
+	store := openTemp(t, "distinct.jsonl")
+	writeEntries(t, store,
+		`{"env":"prod","number":0}`,
+		`{"env":"dev","number":1}`,
+		`{"number":2}`,
+		`{"env":"prod","number":3}`,
+		`["env"]`,
+		`{"env":"staging","number":4}`,
+		`{"env":"dev","number":5}`,
+	)
+	values, err := store.DistinctValues("env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range values {
+		got = append(got, string(v))
+	}
+	want := []string{`"prod"`, `"dev"`, `"staging"`}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got wrong distinct values. Expected (%v), got (%v)", want, got)
+	}
+}
+
+func TestIndexOf(t *testing.T) {
+	store := openTemp(t, "indexof.jsonl")
+	writeEntries(t, store,
+		`{"env":"dev","number":0}`,
+		`{"env":"prod","number":1}`,
+		`{"env":"dev","number":2}`,
+		`{"env":"prod","number":3}`,
+	)
+	isEnv := func(env string) func([]byte) (bool, error) {
+		return func(entry []byte) (bool, error) {
+			v, ok := lookup(entry, "env")
+			return ok && string(v) == `"`+env+`"`, nil
+		}
+	}
+	i, err := store.IndexOf(isEnv("prod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 1 {
+		t.Fatalf("expected IndexOf() of (%d), got (%d)", 1, i)
+	}
+	i, err = store.IndexOf(isEnv("staging"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != -1 {
+		t.Fatalf("expected IndexOf() of (%d) for a missing entry, got (%d)", -1, i)
+	}
+}
+
+func TestCountWhere(t *testing.T) {
+	store := openTemp(t, "countwhere.jsonl")
+	for i := 0; i < 12; i++ {
+		writeEntries(t, store, fmt.Sprintf(`{"number":%d}`, i))
+	}
+	even := func(entry []byte) (bool, error) {
+		var v struct {
+			Number int `json:"number"`
+		}
+		if err := json.Unmarshal(entry, &v); err != nil {
+			return false, err
+		}
+		return v.Number%2 == 0, nil
+	}
+	n, err := store.CountWhere(even)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 {
+		t.Fatalf("got wrong count from CountWhere(). Expected (6), got (%d)", n)
+	}
+	errMatch := errors.New("match failed")
+	if _, err := store.CountWhere(func([]byte) (bool, error) { return false, errMatch }); !errors.Is(err, errMatch) {
+		t.Fatalf("expected the error from match, got (%v)", err)
+	}
+}
@@ -0,0 +1,158 @@
+package jsonl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// OpenRotated opens the jsonl file basename along with the segments
+// rotated out of it, basename.1 being the newest and basename.2 the
+// next newest, and so on until one is missing. Reads see the
+// segments as one store, oldest first, while writes go to basename
+// alone. The segments are found when the store is opened, so those
+// rotated afterwards are not seen. Rotated segments must end in a
+// complete entry and, other than the oldest, have no header.
+// WithMmap() is not supported.
+func OpenRotated(basename string, opts ...Option) (*Jsonl, error) {
+	var cfg config
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.mmap {
+		return nil, fmt.Errorf("jsonl: mapping rotated segments: %w", errors.ErrUnsupported)
+	}
+	r := &rotatedFile{}
+	for i := 1; ; i++ {
+		f, err := os.Open(basename + "." + strconv.Itoa(i))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		// Segments are listed oldest first.
+		r.segments = append([]*os.File{f}, r.segments...)
+	}
+	for _, f := range r.segments {
+		stat, err := f.Stat()
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		r.sizes = append(r.sizes, stat.Size())
+		r.prefix += stat.Size()
+	}
+	active, err := os.OpenFile(basename, cfg.openFlag(), 0o600)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	r.active = active
+	j, err := Open(r, opts...)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// rotatedFile is a File reading rotated segments followed by the
+// active file as one file, and writing to the active file.
+type rotatedFile struct {
+	segments []*os.File
+	sizes    []int64
+	// prefix is the combined size of the segments.
+	prefix int64
+	active *os.File
+}
+
+func (r *rotatedFile) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for i, f := range r.segments {
+		if len(p) == 0 {
+			return n, nil
+		}
+		if off >= r.sizes[i] {
+			off -= r.sizes[i]
+			continue
+		}
+		want := min(int64(len(p)), r.sizes[i]-off)
+		m, err := f.ReadAt(p[:want], off)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if int64(m) < want {
+			// The segment has shrunk, which rotated segments must not.
+			return n, io.ErrUnexpectedEOF
+		}
+		p, off = p[m:], 0
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	m, err := r.active.ReadAt(p, off)
+	return n + m, err
+}
+
+func (r *rotatedFile) Write(p []byte) (int, error) {
+	return r.active.Write(p)
+}
+
+func (r *rotatedFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < r.prefix {
+		return 0, fmt.Errorf("jsonl: writing to a rotated segment: %w", ErrReadOnly)
+	}
+	return r.active.WriteAt(p, off-r.prefix)
+}
+
+func (r *rotatedFile) Truncate(size int64) error {
+	if size < r.prefix {
+		return fmt.Errorf("jsonl: truncating a rotated segment: %w", ErrReadOnly)
+	}
+	return r.active.Truncate(size - r.prefix)
+}
+
+func (r *rotatedFile) Stat() (os.FileInfo, error) {
+	stat, err := r.active.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return rotatedInfo{FileInfo: stat, size: r.prefix + stat.Size()}, nil
+}
+
+func (r *rotatedFile) Sync() error {
+	return r.active.Sync()
+}
+
+// Fd returns the descriptor of the active file, for locking it.
+func (r *rotatedFile) Fd() uintptr {
+	return r.active.Fd()
+}
+
+func (r *rotatedFile) Close() error {
+	var err error
+	for _, f := range r.segments {
+		err = errors.Join(err, f.Close())
+	}
+	if r.active != nil {
+		err = errors.Join(err, r.active.Close())
+	}
+	return err
+}
+
+// rotatedInfo describes the active file, with the combined size of
+// it and the rotated segments.
+type rotatedInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i rotatedInfo) Size() int64 { return i.size }
@@ -0,0 +1,57 @@
+package jsonl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenRotated(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rotated.jsonl")
+	for filename, contents := range map[string]string{
+		name + ".2": "{\"number\":0}\n{\"number\":1}\n",
+		name + ".1": "{\"number\":2}\n",
+		name:        "{\"number\":3}\n",
+	} {
+		if err := os.WriteFile(filename, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := OpenRotated(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	writeEntries(t, store, `{"number":4}`)
+	got, err := collect(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"number":0},{"number":1},{"number":2},{"number":3},{"number":4}`
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got wrong entries from All(). Expected (%s), got (%s)", want, strings.Join(got, ","))
+	}
+	count, err := store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Fatalf("expected Count() of (%d), got (%d)", 5, count)
+	}
+	if b, err := store.ReadBytes(); err != nil || string(b) != `{"number":4}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", `{"number":4}`, b, err)
+	}
+	// Writes only go to the active file.
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"number\":3}\n{\"number\":4}\n" {
+		t.Fatalf("got wrong active file contents (%q)", b)
+	}
+	if _, err := OpenRotated(name, WithMmap()); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected errors.ErrUnsupported for WithMmap(), got (%v)", err)
+	}
+}
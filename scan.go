@@ -0,0 +1,863 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// All returns an iterator over the non-corrupt entries in the file,
+// oldest first. Entries written after iteration begins are not
+// included. If reading the file fails, the error is yielded with a
+// nil entry and iteration stops.
+func (j *Jsonl) All() iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		view, release, err := j.snapshot("All")
+		if err == nil {
+			defer release()
+			err = view.entries(func(_ int64, entry []byte) error {
+				if !yield(append([]byte(nil), entry...), nil) {
+					return errStop
+				}
+				return nil
+			})
+		}
+		if err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Lines returns an iterator over the entries in the file, oldest
+// first, for callers preferring a single-value iterator to All().
+// Unlike All(), a corrupt entry ends the iteration, as do errors
+// reading the file, and the error is then reported by LinesErr().
+// Blank lines, and a partial write at the end of the file, are not
+// considered corrupt.
+func (j *Jsonl) Lines() iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		view, release, err := j.snapshot("Lines")
+		if err == nil {
+			defer release()
+			line := 0
+			err = view.scanForward(func(off int64, b []byte) error {
+				line++
+				e, ok := entry(b)
+				if !ok {
+					if len(e) > 0 {
+						return fmt.Errorf("%w: line %d at offset %d", ErrCorrupt, line, off)
+					}
+					return nil
+				}
+				e, err := view.apply(e)
+				if err != nil {
+					return err
+				}
+				if !yield(append([]byte(nil), e...)) {
+					return errStop
+				}
+				return nil
+			})
+		}
+		j.mu.Lock()
+		j.linesErr = err
+		j.mu.Unlock()
+	}
+}
+
+// LinesErr returns the error which ended the last iteration of
+// Lines(), or nil if it ended without error, as bufio.Scanner's Err()
+// does. It should be checked once iteration finishes.
+func (j *Jsonl) LinesErr() error {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.linesErr
+}
+
+// Entries returns the non-corrupt entries in the file, oldest first.
+// It is the eager counterpart to All(), holding every entry in memory
+// at once, so All() should be preferred for large files. The entries
+// share a single allocation.
+func (j *Jsonl) Entries() ([][]byte, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	var buf []byte
+	var ends []int
+	err := j.entries("Entries", func(_ int64, entry []byte) error {
+		buf = append(buf, entry...)
+		ends = append(ends, len(buf))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([][]byte, len(ends))
+	start := 0
+	for i, end := range ends {
+		// Limit the capacity so appending to one entry can't
+		// overwrite the next.
+		entries[i] = buf[start:end:end]
+		start = end
+	}
+	return entries, nil
+}
+
+// ReadAtOffset returns a copy of the first non-corrupt entry
+// beginning at or after the byte offset, along with the offset just
+// past it, from which the following entry may be read. It returns
+// io.EOF if there are no further entries. This lets stream
+// processors record their position and resume from it:
+//
+//	for off := int64(0); ; {
+//		entry, next, err := j.ReadAtOffset(off)
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//		off = next
+//	}
+func (j *Jsonl) ReadAtOffset(offset int64) ([]byte, int64, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	view, err := j.view("ReadAtOffset")
+	if err != nil {
+		return nil, 0, err
+	}
+	// An offset within a line skips to the start of the next.
+	skip := false
+	if offset > view.start {
+		if offset >= view.size {
+			return nil, 0, io.EOF
+		}
+		prev := make([]byte, 1)
+		if _, err := view.f.ReadAt(prev, offset-1); err != nil {
+			return nil, 0, fmt.Errorf("jsonl failed reading the underlying file: %w", err)
+		}
+		skip = prev[0] != '\n'
+		view.start = offset
+	}
+	var found []byte
+	var next int64
+	err = view.scanForward(func(off int64, line []byte) error {
+		if skip {
+			skip = false
+			return nil
+		}
+		e, ok := entry(line)
+		if !ok {
+			return nil
+		}
+		e, err := view.apply(e)
+		if err != nil {
+			return err
+		}
+		found = append([]byte(nil), e...)
+		next = off + int64(len(line)) + 1
+		return errStop
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if found == nil {
+		return nil, 0, io.EOF
+	}
+	return found, next, nil
+}
+
+// HistoryDecoder returns a json.Decoder which decodes the
+// non-corrupt entries in the file, oldest first, returning io.EOF
+// after the last. The returned cleanup func must be called once the
+// decoder is no longer needed.
+func (j *Jsonl) HistoryDecoder() (*json.Decoder, func() error, error) {
+	view, release, err := j.snapshot("HistoryDecoder")
+	if err != nil {
+		return nil, nil, err
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer release()
+		err := view.entries(func(_ int64, entry []byte) error {
+			if _, err := pw.Write(entry); err != nil {
+				return err
+			}
+			_, err := pw.Write([]byte("\n"))
+			return err
+		})
+		_ = pw.CloseWithError(err)
+	}()
+	cleanup := func() error {
+		err := pr.Close()
+		<-done
+		return err
+	}
+	return json.NewDecoder(pr), cleanup, nil
+}
+
+// Count returns the number of non-corrupt entries in the file.
+func (j *Jsonl) Count() (int, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	view, err := j.view("Count")
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = view.stored().entries(func(_ int64, _ []byte) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// ApproxCount returns the number of non-corrupt entries in the file
+// from a count maintained as entries are written and the file is
+// rewritten, so it is cheap enough to poll. The file is only scanned
+// if the entries have not yet been counted. Entries written by other
+// handles may not be included until the next Write(). It returns -1
+// if the entries can't be counted.
+func (j *Jsonl) ApproxCount() int {
+	j.mu.RLock()
+	count := j.count
+	j.mu.RUnlock()
+	if count >= 0 {
+		return count
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	count, err := j.entryCount("ApproxCount")
+	if err != nil {
+		return -1
+	}
+	return count
+}
+
+// AtLeast reports whether the file holds at least n non-corrupt
+// entries. Unlike Count() it stops scanning once n entries are seen.
+func (j *Jsonl) AtLeast(n int) (bool, error) {
+	if n <= 0 {
+		return true, nil
+	}
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	view, err := j.view("AtLeast")
+	if err != nil {
+		return false, err
+	}
+	count := 0
+	err = view.stored().entries(func(_ int64, _ []byte) error {
+		count++
+		if count == n {
+			return errStop
+		}
+		return nil
+	})
+	return count == n, err
+}
+
+// Page returns up to limit non-corrupt entries, oldest first,
+// starting from the entry at the zero-based index offset. It also
+// reports whether further entries follow the page, for paginating
+// through history.
+func (j *Jsonl) Page(offset, limit int) ([][]byte, bool, error) {
+	if offset < 0 || limit < 0 {
+		return nil, false, fmt.Errorf("jsonl: negative page offset (%d) or limit (%d)", offset, limit)
+	}
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	var page [][]byte
+	i, more := 0, false
+	err := j.entries("Page", func(_ int64, entry []byte) error {
+		switch {
+		case i < offset:
+		case i < offset+limit:
+			page = append(page, append([]byte(nil), entry...))
+		default:
+			more = true
+			return errStop
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return page, more, nil
+}
+
+// GetRange returns copies of the non-corrupt entries with zero-based
+// indices from up to but excluding to, oldest first, for paginating
+// history by version. The range is clamped to the entries available,
+// so that one ending at or before index zero is empty, and an error is
+// returned if from is after to. If the store was
+// opened WithSidecar(), the scan begins at the offset it records for
+// the entry at from, rather than at the start of the file.
+func (j *Jsonl) GetRange(from, to int) ([][]byte, error) {
+	if from > to {
+		return nil, fmt.Errorf("jsonl: range start (%d) is after its end (%d)", from, to)
+	}
+	from, to = max(from, 0), max(to, 0)
+	if to <= from {
+		return nil, nil
+	}
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	view, err := j.view("GetRange")
+	if err != nil {
+		return nil, err
+	}
+	i := 0
+	if j.meta != nil {
+		if m, err := j.Meta(from); err == nil && view.lineStart(m.Offset) {
+			view.start, i = m.Offset, from
+		}
+	}
+	var entries [][]byte
+	err = view.entries(func(_ int64, entry []byte) error {
+		if i >= from {
+			entries = append(entries, append([]byte(nil), entry...))
+		}
+		i++
+		if i >= to {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// lineStart reports whether off is the start of a line in the view.
+func (v view) lineStart(off int64) bool {
+	if off == v.start {
+		return true
+	}
+	if off < v.start || off >= v.size {
+		return false
+	}
+	prev := make([]byte, 1)
+	_, err := v.f.ReadAt(prev, off-1)
+	return err == nil && prev[0] == '\n'
+}
+
+// errStop is returned by scan callbacks to end a scan early.
+var errStop = errors.New("jsonl: stop scan")
+
+// stopped converts errStop into a nil error.
+func stopped(err error) error {
+	if errors.Is(err, errStop) {
+		return nil
+	}
+	return err
+}
+
+// entry trims the whitespace and NUL bytes surrounding line and
+// reports whether the result is a non-corrupt entry. Blank lines,
+// such as those left by manual editing, and runs of NUL bytes, such
+// as holes left in sparse files by preallocation or a crash, are not
+// entries.
+func entry(line []byte) ([]byte, bool) {
+	line = bytes.TrimFunc(line, isFill)
+	if len(line) == 0 {
+		return line, false
+	}
+	return line, json.Valid(line)
+}
+
+// isFill reports whether r is whitespace or a NUL byte, which are
+// not part of an entry.
+func isFill(r rune) bool {
+	return r == 0 || unicode.IsSpace(r)
+}
+
+// view is a snapshot of the region of the file holding entries.
+// Because the file is append-only, the region remains valid after
+// further writes.
+type view struct {
+	f io.ReaderAt
+	// start is the offset of the first entry, after any header.
+	start int64
+	size  int64
+	// limit is the maximum entry size.
+	limit int64
+	// timeout is the read deadline applied to each scan.
+	timeout time.Duration
+	// sem, if set, bounds the number of concurrent backward scans.
+	sem chan struct{}
+	// newestFirst is set if the entries are stored newest first.
+	newestFirst bool
+	// transform, if set, is applied to each entry the view emits,
+	// as set WithReadTransform().
+	transform func(entry []byte) ([]byte, error)
+}
+
+// view returns a view of the entries currently in the file. The
+// caller must hold j.mu.
+func (j *Jsonl) view(op string) (view, error) {
+	if j.f == nil {
+		return view{}, os.ErrNotExist
+	}
+	stat, err := j.stat(op)
+	if err != nil {
+		return view{}, err
+	}
+	var f io.ReaderAt = j.f
+	if j.mm != nil {
+		f = j.mm.readerAt(j.f, stat.Size())
+	}
+	if j.cfg.readAttempts > 1 {
+		f = retryReader{f: f, attempts: j.cfg.readAttempts, backoff: j.cfg.readBackoff}
+	}
+	v := view{
+		f:           f,
+		start:       j.cfg.headerBytes,
+		size:        stat.Size(),
+		limit:       j.cfg.maxEntrySize(),
+		timeout:     j.cfg.readTimeout,
+		sem:         j.cfg.readSem,
+		newestFirst: j.cfg.newestFirst,
+	}
+	if j.cfg.readTransform != nil {
+		v.transform = j.readTransform
+	}
+	return v, nil
+}
+
+// stored returns the view without its read transform, for reading
+// entries as they're stored, such as to count or rewrite them.
+func (v view) stored() view {
+	v.transform = nil
+	return v
+}
+
+// apply returns entry as the view emits it, after any read transform.
+func (v view) apply(entry []byte) ([]byte, error) {
+	if v.transform == nil {
+		return entry, nil
+	}
+	return v.transform(entry)
+}
+
+// readView returns a view of the file for reading its latest entry,
+// and a func to release it once done. j.mu is held for reading until
+// then, unless opened WithSnapshotReads(), in which case j.mu is only
+// held to find the size of the file, so that appends may proceed
+// during the read.
+func (j *Jsonl) readView(op string) (view, func(), error) {
+	j.mu.RLock()
+	view, err := j.view(op)
+	if err != nil {
+		j.mu.RUnlock()
+		return view, nil, err
+	}
+	if !j.cfg.snapshotReads {
+		return view, j.mu.RUnlock, nil
+	}
+	release := j.acquire()
+	j.mu.RUnlock()
+	return view, release, nil
+}
+
+// acquire records a read of the current file made without j.mu held,
+// returning a func to call once it's done, so that the file isn't
+// closed under the read if it's replaced or released meanwhile. The
+// caller must hold j.mu.
+func (j *Jsonl) acquire() func() {
+	seq := j.fileSeq
+	j.refMu.Lock()
+	defer j.refMu.Unlock()
+	if j.readers == nil {
+		j.readers = make(map[uint64]int)
+	}
+	j.readers[seq]++
+	return func() {
+		j.refMu.Lock()
+		j.readers[seq]--
+		var closeFile func() error
+		if j.readers[seq] == 0 {
+			delete(j.readers, seq)
+			closeFile = j.retired[seq]
+			delete(j.retired, seq)
+		}
+		j.refMu.Unlock()
+		if closeFile != nil {
+			// The error has nobody to report to, as the store has
+			// moved on from the file.
+			_ = closeFile()
+		}
+	}
+}
+
+// closer returns a func closing f, a file the store no longer holds,
+// along with any mappings of it.
+func (j *Jsonl) closer(f File) func() error {
+	mm := j.mm
+	return func() error {
+		err := f.Close()
+		if mm != nil {
+			err = errors.Join(err, mm.release(f))
+		}
+		return err
+	}
+}
+
+// retire releases the current file by calling closeFile, once any
+// reads of it in progress are done, before the store replaces or
+// closes it. Only an error closing the file immediately is returned.
+// The caller must hold j.mu for writing.
+func (j *Jsonl) retire(closeFile func() error) error {
+	seq := j.fileSeq
+	j.fileSeq++
+	j.refMu.Lock()
+	if j.readers[seq] > 0 {
+		if j.retired == nil {
+			j.retired = make(map[uint64]func() error)
+		}
+		j.retired[seq] = closeFile
+		j.refMu.Unlock()
+		return nil
+	}
+	j.refMu.Unlock()
+	return closeFile()
+}
+
+// sleep is time.Sleep, replaced by tests.
+var sleep = time.Sleep
+
+// retryReader retries failed reads of f for WithReadRetry(), as
+// flaky storage may fail a read which then succeeds.
+type retryReader struct {
+	f        io.ReaderAt
+	attempts int
+	backoff  time.Duration
+}
+
+func (r retryReader) ReadAt(p []byte, off int64) (int, error) {
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		n, err := r.f.ReadAt(p, off)
+		if err == nil || attempt >= r.attempts || !transient(err) {
+			return n, err
+		}
+		sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// SetReadDeadline sets the read deadline of f, if it supports one.
+func (r retryReader) SetReadDeadline(t time.Time) error {
+	d, ok := r.f.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return os.ErrNoDeadline
+	}
+	return d.SetReadDeadline(t)
+}
+
+// transient reports whether a failed read may succeed if retried.
+func transient(err error) bool {
+	return !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrClosed) && !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// deadline sets the read deadline of the view on backends which
+// support one, returning a func which clears it.
+func (v view) deadline() func() {
+	d, ok := v.f.(interface{ SetReadDeadline(time.Time) error })
+	if v.timeout <= 0 || !ok {
+		return func() {}
+	}
+	if err := d.SetReadDeadline(time.Now().Add(v.timeout)); err != nil {
+		// os.ErrNoDeadline for regular files.
+		return func() {}
+	}
+	return func() { _ = d.SetReadDeadline(time.Time{}) }
+}
+
+// snapshot returns a view of the entries currently in the file
+// without holding j.mu afterwards, and a func to call once done with
+// it. It is used where caller code runs during a scan, so that the
+// caller may use the store meanwhile, even to rewrite it.
+func (j *Jsonl) snapshot(op string) (view, func(), error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	view, err := j.view(op)
+	if err != nil {
+		return view, nil, err
+	}
+	return view, j.acquire(), nil
+}
+
+// entries calls fn for each non-corrupt entry, oldest first. The
+// caller must hold j.mu.
+func (j *Jsonl) entries(op string, fn func(off int64, entry []byte) error) error {
+	view, err := j.view(op)
+	if err != nil {
+		return err
+	}
+	return view.entries(fn)
+}
+
+// entriesReverse calls fn for each non-corrupt entry in the reverse
+// of the order they're stored in, which is newest first unless
+// opened WithNewestFirst().
+// The caller must hold j.mu.
+func (j *Jsonl) entriesReverse(op string, fn func(off int64, entry []byte) error) error {
+	view, err := j.view(op)
+	if err != nil {
+		return err
+	}
+	return view.entriesReverse(fn)
+}
+
+// newestEntries calls fn for each non-corrupt entry, newest first,
+// whichever order the entries are stored in. The caller must hold
+// j.mu.
+func (j *Jsonl) newestEntries(op string, fn func(off int64, entry []byte) error) error {
+	view, err := j.view(op)
+	if err != nil {
+		return err
+	}
+	return view.newestEntries(fn)
+}
+
+// entries calls fn for each non-corrupt entry, oldest first.
+func (v view) entries(fn func(off int64, entry []byte) error) error {
+	return v.scanForward(func(off int64, line []byte) error {
+		e, ok := entry(line)
+		if !ok {
+			return nil
+		}
+		e, err := v.apply(e)
+		if err != nil {
+			return err
+		}
+		return fn(off, e)
+	})
+}
+
+// newestEntries calls fn for each non-corrupt entry, newest first,
+// reading forwards if the entries are stored newest first.
+func (v view) newestEntries(fn func(off int64, entry []byte) error) error {
+	if v.newestFirst {
+		return v.entries(fn)
+	}
+	return v.entriesReverse(fn)
+}
+
+// entriesReverse calls fn for each non-corrupt entry in the reverse
+// of the order they're stored in, which is newest first unless
+// opened WithNewestFirst().
+func (v view) entriesReverse(fn func(off int64, entry []byte) error) error {
+	return v.scanBackward(func(off int64, line []byte) error {
+		e, ok := entry(line)
+		if !ok {
+			return nil
+		}
+		e, err := v.apply(e)
+		if err != nil {
+			return err
+		}
+		return fn(off, e)
+	})
+}
+
+// latest calls fn with the latest non-corrupt entry in the view,
+// returning io.EOF if there is none, or ErrNoValidEntry if the view
+// holds only corrupt data. The entry passed to fn must not be
+// retained.
+func (v view) latest(fn func(entry []byte) error) error {
+	scan := v.scanBackward
+	if v.newestFirst {
+		scan = v.scanForward
+	}
+	found, garbage := false, false
+	err := scan(func(_ int64, line []byte) error {
+		e, ok := entry(line)
+		if !ok {
+			garbage = garbage || len(e) > 0
+			return nil
+		}
+		found = true
+		e, err := v.apply(e)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+		return errStop
+	})
+	if err != nil || found {
+		return err
+	}
+	if !garbage {
+		tail, err := v.tail()
+		if err != nil {
+			return err
+		}
+		garbage = tail < v.size
+	}
+	if garbage {
+		return ErrNoValidEntry
+	}
+	return io.EOF
+}
+
+// tail returns the offset of the partial write at the end of the
+// view, which is the size of the view if there is none.
+func (v view) tail() (int64, error) {
+	tail := v.start
+	err := v.scanBackward(func(off int64, line []byte) error {
+		tail = off + int64(len(line)) + 1
+		return errStop
+	})
+	if err != nil {
+		return 0, err
+	}
+	return tail, nil
+}
+
+// scanForward calls fn with the offset and contents of each
+// newline-terminated line in the view, oldest first, until fn
+// returns an error or the end of the view is reached. Any bytes
+// after the last newline are a partial write and are skipped. If fn
+// returns errStop the scan ends without error. The line passed to fn
+// must not be retained.
+func (v view) scanForward(fn func(off int64, line []byte) error) error {
+	off := v.start
+	if v.size <= off {
+		return nil
+	}
+	defer v.deadline()()
+	r := bufio.NewReaderSize(io.NewSectionReader(v.f, off, v.size-off), int(chunkSize))
+	var line []byte
+	for {
+		frag, err := r.ReadSlice('\n')
+		line = append(line, frag...)
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			if int64(len(line)) > v.limit {
+				return fmt.Errorf("%w: read limit is %d bytes", ErrEntryTooLarge, v.limit)
+			}
+			continue
+		case errors.Is(err, io.EOF):
+			// Anything after the last newline is a partial write.
+			return nil
+		case err != nil:
+			return fmt.Errorf("jsonl failed reading the underlying file: %w", err)
+		}
+		if err := fn(off, line[:len(line)-1]); err != nil {
+			return stopped(err)
+		}
+		off += int64(len(line))
+		line = line[:0]
+	}
+}
+
+// bufPool holds buffers for scanBackward.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, chunkSize)
+		return &b
+	},
+}
+
+// maxPooledBuf is the largest buffer returned to bufPool, so that
+// an occasional large entry doesn't pin its buffer in memory.
+const maxPooledBuf = 64 * 1024
+
+// scanBackward calls fn with the offset and contents of each
+// newline-terminated line in the view, newest first, until fn
+// returns an error or the start of the view is reached. Any bytes
+// after the last newline are a partial write and are skipped. If fn
+// returns errStop the scan ends without error. The line passed to fn
+// must not be retained, as the buffer holding it is pooled.
+func (v view) scanBackward(fn func(off int64, line []byte) error) error {
+	pos := v.size // file offset of buf[lo]
+	if pos < v.start {
+		return nil
+	}
+	if v.sem != nil {
+		v.sem <- struct{}{}
+		defer func() { <-v.sem }()
+	}
+	defer v.deadline()()
+	bp := bufPool.Get().(*[]byte)
+	buf := (*bp)[:cap(*bp)]
+	defer func() {
+		if cap(buf) <= maxPooledBuf {
+			*bp = buf
+		}
+		bufPool.Put(bp)
+	}()
+	// Data is read backwards into the end of buf, which holds the
+	// unscanned bytes in buf[lo:hi].
+	lo, hi := len(buf), len(buf)
+	// terminated is set once the newline ending the current line is found.
+	terminated := false
+	for {
+		if i := bytes.LastIndexByte(buf[lo:hi], '\n'); i >= 0 {
+			if terminated {
+				if err := fn(pos+int64(i)+1, buf[lo+i+1:hi]); err != nil {
+					return stopped(err)
+				}
+			}
+			terminated = true
+			hi = lo + i
+			continue
+		}
+		if pos == v.start {
+			if terminated {
+				return stopped(fn(v.start, buf[lo:hi]))
+			}
+			return nil
+		}
+		// Entries larger than this are not supported. If you need entries larger
+		// than this, you should probably be using a database.
+		pending := int64(hi - lo)
+		if pending > v.limit {
+			return fmt.Errorf("%w: read limit is %d bytes", ErrEntryTooLarge, v.limit)
+		}
+		// Grow the read size with the line so that long lines don't cost
+		// quadratic copying, but never past the limit.
+		n := chunkSize
+		if pending > n {
+			n = pending
+		}
+		if n > v.limit+1-pending {
+			n = v.limit + 1 - pending
+		}
+		if n > pos-v.start {
+			n = pos - v.start
+		}
+		if int64(lo) < n {
+			// Double the buffer, but never past the limit, so that
+			// reading an entry never buffers more than the limit, as
+			// WithMaxMemory() promises.
+			grow := min(2*(pending+n), v.limit+1)
+			grown := make([]byte, grow)
+			copy(grown[len(grown)-int(pending):], buf[lo:hi])
+			buf = grown
+			lo, hi = len(buf)-int(pending), len(buf)
+		}
+		m, err := v.f.ReadAt(buf[lo-int(n):lo], pos-n)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("jsonl failed reading the underlying file: %w", err)
+		}
+		// The file may have been truncated since the view was taken,
+		// so don't leave stale bytes in place of those not read.
+		clear(buf[lo-int(n)+m : lo])
+		lo -= int(n)
+		pos -= n
+	}
+}
@@ -0,0 +1,536 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAllAndCount(t *testing.T) {
+	store := openTemp(t, "all.jsonl")
+	entries := []string{`{"number":0}`, `{"number":1}`, `{"number":2}`}
+	writeEntries(t, store, entries...)
+
+	// A partial write at the tail is not an entry.
+	if _, err := store.f.Write([]byte(`{"number":`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for entry, err := range store.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(entry))
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("expected (%d) entries from All(), got (%d)", len(entries), len(got))
+	}
+	for i := range entries {
+		if got[i] != entries[i] {
+			t.Fatalf("got wrong entry (%d) from All(). Expected (%s), got (%s)", i, entries[i], got[i])
+		}
+	}
+	count, err := store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(entries) {
+		t.Fatalf("expected Count() of (%d), got (%d)", len(entries), count)
+	}
+}
+
+func TestAllDuringCompact(t *testing.T) {
+	store := openTemp(t, "compact.jsonl")
+	const n = 1000
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "{\"number\":%d}\n", i)
+	}
+	if _, err := store.WriteLines([]byte(b.String())); err != nil {
+		t.Fatal(err)
+	}
+	i := 0
+	for entry, err := range store.All() {
+		if err != nil {
+			t.Fatalf("got error from All() after (%d) entries: %v", i, err)
+		}
+		if want := fmt.Sprintf(`{"number":%d}`, i); string(entry) != want {
+			t.Fatalf("got wrong entry from All(). Expected (%s), got (%s)", want, entry)
+		}
+		if i == 0 {
+			// The file iterated is replaced, but not closed until the
+			// iteration is done.
+			if err := store.Compact(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		i++
+	}
+	if i != n {
+		t.Fatalf("expected (%d) entries from All(), got (%d)", n, i)
+	}
+	store.refMu.Lock()
+	defer store.refMu.Unlock()
+	if len(store.readers) != 0 || len(store.retired) != 0 {
+		t.Fatalf("expected the replaced file to be released, got (%d) readers and (%d) retired", len(store.readers), len(store.retired))
+	}
+}
+
+func TestBlankLines(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "blank.jsonl")
+	data := "\n{\"number\":0}\n\n  \n{\"number\":1}\n\t\r\n{\"number\":2}\n \n\n"
+	if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	count, err := store.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected Count() of (%d), got (%d)", 3, count)
+	}
+	i := 0
+	for entry, err := range store.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf(`{"number":%d}`, i); string(entry) != want {
+			t.Fatalf("got wrong entry from All(). Expected (%s), got (%q)", want, entry)
+		}
+		i++
+	}
+	latest, err := store.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"number":2}` {
+		t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%q)", `{"number":2}`, latest)
+	}
+}
+
+func TestEscapedNewlines(t *testing.T) {
+	type Entry struct {
+		Text string `json:"text"`
+	}
+	store := openTemp(t, "escaped.jsonl")
+	texts := []string{"line one\nline two", "tab\tseparated\r\n", "\n"}
+	writer := json.NewEncoder(store)
+	for _, text := range texts {
+		if err := writer.Encode(&Entry{Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Whitespace between tokens, including raw newlines, is compacted away.
+	writeEntries(t, store, "{\n\t\"text\": \"pretty\"\n}\n")
+	texts = append(texts, "pretty")
+
+	i := 0
+	for b, err := range store.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e Entry
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Text != texts[i] {
+			t.Fatalf("got wrong entry (%d) from All(). Expected (%q), got (%q)", i, texts[i], e.Text)
+		}
+		i++
+	}
+	if i != len(texts) {
+		t.Fatalf("expected (%d) entries from All(), got (%d)", len(texts), i)
+	}
+	latest := Entry{}
+	if err := store.Decode(&latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Text != "pretty" {
+		t.Fatalf("got wrong entry from Decode(). Expected (%q), got (%q)", "pretty", latest.Text)
+	}
+	last, err := LastN[Entry](store, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last[0].Text != "\n" {
+		t.Fatalf("got wrong entry from LastN(). Expected (%q), got (%q)", "\n", last[0].Text)
+	}
+}
+
+func TestHistoryDecoder(t *testing.T) {
+	type Entry struct {
+		V int `json:"number"`
+	}
+	store := openTemp(t, "history.jsonl")
+	writer := json.NewEncoder(store)
+	for i := 0; i < 5; i++ {
+		if err := writer.Encode(&Entry{V: i}); err != nil {
+			t.Fatal(err)
+		}
+		// Mid-file garbage, demarcated by the next write.
+		if _, err := store.f.Write([]byte(`{"number":`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec, cleanup, err := store.HistoryDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	count := 0
+	for {
+		var e Entry
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.V != count {
+			t.Fatalf("got wrong entry. Expected (%d), got (%d)", count, e.V)
+		}
+		count++
+	}
+	if count != 5 {
+		t.Fatalf("expected (%d) entries, got (%d)", 5, count)
+	}
+
+	// Cleaning up before the end stops decoding.
+	dec, cleanup, err = store.HistoryDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	var e Entry
+	if err := dec.Decode(&e); err == nil {
+		t.Fatal("expected an error decoding after cleanup")
+	}
+}
+
+// countingFile is a File which counts the bytes read from it.
+type countingFile struct {
+	*os.File
+	read int64
+}
+
+func (f *countingFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	atomic.AddInt64(&f.read, int64(n))
+	return n, err
+}
+
+func TestAtLeast(t *testing.T) {
+	plain := openTemp(t, "atleast.jsonl")
+	var lines []byte
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf("{\"number\":%d}\n", i)...)
+	}
+	if _, err := plain.WriteLines(lines); err != nil {
+		t.Fatal(err)
+	}
+	backend := &countingFile{File: plain.f.(*os.File)}
+	store, err := Open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := store.AtLeast(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected AtLeast(10) to be true")
+	}
+	if backend.read >= int64(len(lines)) {
+		t.Fatalf("expected AtLeast() to stop early, but it read (%d) of (%d) bytes", backend.read, len(lines))
+	}
+	for n, want := range map[int]bool{1000: true, 1001: false, 0: true} {
+		ok, err := store.AtLeast(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Fatalf("expected AtLeast(%d) to be (%t), got (%t)", n, want, ok)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	store := openTemp(t, "page.jsonl")
+	for i := 0; i < 25; i++ {
+		writeEntries(t, store, fmt.Sprintf(`{"number":%d}`, i))
+	}
+	for _, tc := range []struct {
+		offset, limit int
+		first, n      int
+		more          bool
+	}{
+		{0, 10, 0, 10, true},
+		{10, 10, 10, 10, true},
+		{20, 10, 20, 5, false},
+		{15, 10, 15, 10, false},
+		{25, 10, 0, 0, false},
+	} {
+		page, more, err := store.Page(tc.offset, tc.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != tc.n || more != tc.more {
+			t.Fatalf("got wrong page from Page(%d, %d). Expected (%d) entries and more (%t), got (%d) and (%t)", tc.offset, tc.limit, tc.n, tc.more, len(page), more)
+		}
+		for i, entry := range page {
+			if want := fmt.Sprintf(`{"number":%d}`, tc.first+i); string(entry) != want {
+				t.Fatalf("got wrong entry from Page(). Expected (%s), got (%s)", want, entry)
+			}
+		}
+	}
+	if _, _, err := store.Page(-1, 10); err == nil {
+		t.Fatal("expected an error for a negative offset")
+	}
+}
+
+func TestEntries(t *testing.T) {
+	store := openTemp(t, "entries.jsonl")
+	if entries, err := store.Entries(); err != nil || len(entries) != 0 {
+		t.Fatalf("expected no entries from an empty store, got (%q, %v)", entries, err)
+	}
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`, `{"number":2}`)
+	if _, err := store.f.Write([]byte(`{"number":`)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := collect(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("expected (%d) entries from Entries(), got (%d)", len(want), len(entries))
+	}
+	for i := range want {
+		if string(entries[i]) != want[i] {
+			t.Fatalf("got wrong entry from Entries(). Expected (%s), got (%s)", want[i], entries[i])
+		}
+	}
+	// Appending to an entry must not affect the next.
+	_ = append(entries[0], 'x')
+	if string(entries[1]) != want[1] {
+		t.Fatalf("appending to an entry overwrote the next, got (%s)", entries[1])
+	}
+}
+
+func TestApproxCount(t *testing.T) {
+	store := openTemp(t, "approx.jsonl")
+	if n := store.ApproxCount(); n != 0 {
+		t.Fatalf("expected an ApproxCount() of (0), got (%d)", n)
+	}
+	check := func() {
+		t.Helper()
+		want, err := store.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := store.ApproxCount(); n != want {
+			t.Fatalf("got wrong ApproxCount(). Expected (%d), got (%d)", want, n)
+		}
+	}
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	if _, err := store.WriteLines([]byte("{\"number\":2}\n{\"number\":3}\n")); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if store.count < 0 {
+		t.Fatal("expected Compact() to maintain the count")
+	}
+	check()
+	writeEntries(t, store, `{"number":4}`)
+	check()
+
+	// The newline injected after a partial write may complete it as
+	// an entry.
+	if _, err := store.f.Write([]byte(`{"number":5}`)); err != nil {
+		t.Fatal(err)
+	}
+	// Count the entries with the partial write in place.
+	if _, _, err := store.LatestWithVersion(); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":6}`)
+	check()
+	// Compact() kept one entry, so the entries since are versions 1
+	// to 3.
+	if _, version, err := store.LatestWithVersion(); err != nil || version != 3 {
+		t.Fatalf("got wrong version from LatestWithVersion(). Expected (%d), got (%d, %v)", 3, version, err)
+	}
+}
+
+func TestReadAtOffset(t *testing.T) {
+	store := openTemp(t, "offset.jsonl", WithHeader())
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	if _, err := store.f.Write([]byte("not json\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":2}`, `{"number":3}`)
+	want, err := collect(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	var offsets []int64
+	for off := int64(0); ; {
+		entry, next, err := store.ReadAtOffset(off)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next <= off {
+			t.Fatalf("expected the next offset to advance from (%d), got (%d)", off, next)
+		}
+		got = append(got, string(entry))
+		offsets = append(offsets, off)
+		off = next
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got wrong entries walking by offset. Expected (%q), got (%q)", want, got)
+	}
+	// An offset within an entry resumes from the next.
+	entry, _, err := store.ReadAtOffset(offsets[1] + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entry) != `{"number":2}` {
+		t.Fatalf("got wrong entry from ReadAtOffset(). Expected (%s), got (%s)", `{"number":2}`, entry)
+	}
+}
+
+func TestNULGaps(t *testing.T) {
+	nuls := strings.Repeat("\x00", 64)
+	for name, contents := range map[string]string{
+		"line":   "{\"number\":0}\n" + nuls + "\n{\"number\":1}\n",
+		"prefix": "{\"number\":0}\n" + nuls + "{\"number\":1}\n",
+		"tail":   "{\"number\":0}\n{\"number\":1}\n" + nuls,
+	} {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "sparse.jsonl")
+			if err := os.WriteFile(filename, []byte(contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			store, err := OpenFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if b, err := store.ReadBytes(); err != nil || string(b) != `{"number":1}` {
+				t.Fatalf("got wrong entry from ReadBytes(). Expected (%s), got (%s, %v)", `{"number":1}`, b, err)
+			}
+			got, err := collect(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := `{"number":0},{"number":1}`; strings.Join(got, ",") != want {
+				t.Fatalf("got wrong entries from All(). Expected (%s), got (%s)", want, strings.Join(got, ","))
+			}
+			if err := store.Verify(); err != nil {
+				t.Fatalf("expected the gap not to be corrupt, got (%v)", err)
+			}
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+	store := openTemp(t, "lines.jsonl")
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	var got []string
+	for entry := range store.Lines() {
+		got = append(got, string(entry))
+	}
+	if err := store.LinesErr(); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"number":0},{"number":1}`; strings.Join(got, ",") != want {
+		t.Fatalf("got wrong entries from Lines(). Expected (%s), got (%s)", want, strings.Join(got, ","))
+	}
+	// A corrupt entry ends the iteration.
+	if _, err := store.f.Write([]byte("not json\n")); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, store, `{"number":2}`)
+	got = got[:0]
+	for entry := range store.Lines() {
+		got = append(got, string(entry))
+	}
+	if err := store.LinesErr(); !errors.Is(err, ErrCorrupt) || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expected ErrCorrupt reporting line 3, got (%v)", err)
+	}
+	if want := `{"number":0},{"number":1}`; strings.Join(got, ",") != want {
+		t.Fatalf("got wrong entries from Lines(). Expected (%s), got (%s)", want, strings.Join(got, ","))
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	plain := openTemp(t, "range.jsonl")
+	sidecar, err := OpenFile(filepath.Join(t.TempDir(), "range.jsonl"), WithSidecar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sidecar.Close()
+	for name, store := range map[string]*Jsonl{"plain": plain, "sidecar": sidecar} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				writeEntries(t, store, fmt.Sprintf(`{"number":%d}`, i))
+			}
+			for _, tc := range []struct {
+				from, to int
+				want     string
+			}{
+				{2, 5, `{"number":2},{"number":3},{"number":4}`},
+				{8, 20, `{"number":8},{"number":9}`},
+				{-1, 1, `{"number":0}`},
+				{3, 3, ``},
+				{12, 15, ``},
+				{-3, -1, ``},
+				{-3, 0, ``},
+				{0, 0, ``},
+			} {
+				entries, err := store.GetRange(tc.from, tc.to)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, entry := range entries {
+					got = append(got, string(entry))
+				}
+				if strings.Join(got, ",") != tc.want {
+					t.Fatalf("got wrong entries from GetRange(%d, %d). Expected (%s), got (%s)", tc.from, tc.to, tc.want, strings.Join(got, ","))
+				}
+			}
+			if _, err := store.GetRange(5, 2); err == nil {
+				t.Fatal("expected an error from GetRange() with from after to")
+			}
+		})
+	}
+}
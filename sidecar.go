@@ -0,0 +1,124 @@
+package jsonl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Meta is the metadata recorded for an entry by WithSidecar().
+type Meta struct {
+	// Index is the zero-based index of the entry.
+	Index int `json:"index"`
+	// Offset is the offset of the entry in the file.
+	Offset int64 `json:"offset"`
+	// WrittenAt is when the entry was written.
+	WrittenAt time.Time `json:"writtenAt"`
+}
+
+// sidecarName returns the path of the sidecar of the file at name,
+// which for "store.jsonl" is "store.meta.jsonl".
+func sidecarName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".meta.jsonl"
+}
+
+// Meta returns the metadata recorded for the entry at the zero-based
+// index by a store opened WithSidecar(). An error wrapping
+// fs.ErrNotExist is returned if there is none, such as for entries
+// written before the sidecar was enabled.
+func (j *Jsonl) Meta(index int) (Meta, error) {
+	if j.meta == nil {
+		return Meta{}, fmt.Errorf("jsonl: store was not opened WithSidecar()")
+	}
+	var meta Meta
+	found := false
+	j.meta.mu.RLock()
+	defer j.meta.mu.RUnlock()
+	err := j.meta.entriesReverse("Meta", func(_ int64, entry []byte) error {
+		var m Meta
+		if err := json.Unmarshal(entry, &m); err != nil || m.Index != index {
+			return nil
+		}
+		meta, found = m, true
+		return errStop
+	})
+	if err != nil {
+		return Meta{}, err
+	}
+	if !found {
+		return Meta{}, fmt.Errorf("jsonl: no metadata for entry %d: %w", index, fs.ErrNotExist)
+	}
+	return meta, nil
+}
+
+// writeMeta records metadata in the sidecar for the entries in p,
+// which were written at off and follow the entry at index first. A
+// leading newline in p, demarcating a partial write, is skipped. The
+// caller must hold j.mu for writing.
+func (j *Jsonl) writeMeta(off int64, first int, p []byte) error {
+	if len(p) > 0 && p[0] == '\n' {
+		off, p = off+1, p[1:]
+	}
+	now := time.Now()
+	var lines []byte
+	for i, entry := range bytes.SplitAfter(p[:len(p)-1], []byte("\n")) {
+		b, err := json.Marshal(Meta{Index: first + i, Offset: off, WrittenAt: now})
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, b...), '\n')
+		off += int64(len(entry))
+	}
+	if _, err := j.meta.WriteLines(lines); err != nil {
+		return fmt.Errorf("jsonl failed to write metadata: %w", err)
+	}
+	return nil
+}
+
+// move rewrites the sidecar j after its store was rewritten, where
+// moved maps the previous offset of each remaining entry to its new
+// index and offset. The metadata of removed entries is dropped.
+func (j *Jsonl) move(moved map[int64]Meta) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.rewrite("sidecar", func(view view, emit func(int64, []byte) error) error {
+		// Keep only the latest metadata for each entry.
+		latest := make(map[int64]Meta)
+		err := view.entries(func(_ int64, entry []byte) error {
+			var m Meta
+			if err := json.Unmarshal(entry, &m); err == nil {
+				latest[m.Offset] = m
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		metas := make([]Meta, 0, len(moved))
+		for src, to := range moved {
+			if m, ok := latest[src]; ok {
+				to.WrittenAt = m.WrittenAt
+			}
+			metas = append(metas, to)
+		}
+		slices.SortFunc(metas, func(a, b Meta) int { return a.Index - b.Index })
+		for _, m := range metas {
+			if m.WrittenAt.IsZero() {
+				continue
+			}
+			b, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			if err := emit(m.Offset, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
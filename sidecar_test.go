@@ -0,0 +1,62 @@
+package jsonl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithSidecar(t *testing.T) {
+	store := openTemp(t, "sidecar.jsonl", WithSidecar())
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+	if _, err := store.WriteLines([]byte("{\"number\":2}\n{\"number\":3}\n")); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := store.meta.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines != 4 {
+		t.Fatalf("expected a metadata entry per write, got (%d)", lines)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(store.name), "sidecar.meta.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	var metas []Meta
+	for i := 0; i < 4; i++ {
+		m, err := store.Meta(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Index != i || m.Offset != int64(i*len("{\"number\":0}\n")) || m.WrittenAt.IsZero() {
+			t.Fatalf("got wrong metadata for entry (%d), got (%+v)", i, m)
+		}
+		metas = append(metas, m)
+	}
+	if _, err := store.Meta(4); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist for a missing entry, got (%v)", err)
+	}
+
+	// Dropping the first entry moves the metadata of the rest.
+	err = store.Maintenance(func(entry []byte) (bool, []byte, error) {
+		return string(entry) != `{"number":0}`, nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		m, err := store.Meta(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := metas[i+1]
+		if m.Index != i || m.Offset != metas[i].Offset || !m.WrittenAt.Equal(want.WrittenAt) {
+			t.Fatalf("got wrong metadata for entry (%d) after a rewrite, got (%+v)", i, m)
+		}
+	}
+	if _, err := store.Meta(3); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist for a removed entry, got (%v)", err)
+	}
+}
@@ -0,0 +1,151 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+)
+
+// Snapshot is a read-only view of the entries of a store as they
+// were when it was taken, so that several reads, such as Count()
+// then Latest(), agree with each other. Entries written afterwards
+// are not seen, nor are rewrites such as by Compact().
+type Snapshot struct {
+	j    *Jsonl
+	view view
+	// ranges holds the region of the file holding each entry.
+	ranges []Range
+	// f is the file opened for the snapshot, if any.
+	f *os.File
+	// release releases the store's file if the snapshot reads it.
+	release func()
+}
+
+// Snapshot takes a snapshot of the entries in the store. The regions
+// of the file holding the entries are recorded, so EntryAt() reads
+// only the entry requested. Named files are opened afresh for the
+// snapshot, which holds them open until it's closed so that a rewrite
+// of the store doesn't disturb it. Otherwise the snapshot reads the
+// store's file, which isn't closed by a rewrite, or by closing the
+// store, until the snapshot is closed.
+func (j *Jsonl) Snapshot() (*Snapshot, error) {
+	j.mu.RLock()
+	view, err := j.view("Snapshot")
+	var f *os.File
+	if err == nil && j.name != "" {
+		f, err = j.reopen()
+		if f != nil {
+			view.f = f
+		}
+	}
+	var release func()
+	if err == nil && f == nil {
+		release = j.acquire()
+	}
+	j.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{view: view, f: f, release: release}
+	err = view.scanForward(func(off int64, line []byte) error {
+		if _, ok := entry(line); ok {
+			s.ranges = append(s.ranges, Range{Offset: off, Length: int64(len(line))})
+		}
+		return nil
+	})
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// reopen opens the file afresh for reading, returning nil if the path
+// no longer names the open file, such as after it was replaced by
+// another handle. The caller must hold j.mu.
+func (j *Jsonl) reopen() (*os.File, error) {
+	f, err := os.Open(j.name)
+	if err != nil {
+		return nil, err
+	}
+	ours, err := j.stat("Snapshot")
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	fresh, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if !os.SameFile(ours, fresh) {
+		_ = f.Close()
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Count returns the number of non-corrupt entries in the snapshot.
+func (s *Snapshot) Count() int {
+	return len(s.ranges)
+}
+
+// EntryAt returns a copy of the entry at the zero-based index i,
+// oldest first.
+func (s *Snapshot) EntryAt(i int) ([]byte, error) {
+	if i < 0 || i >= len(s.ranges) {
+		return nil, fmt.Errorf("jsonl: entry %d out of range of %d entries", i, len(s.ranges))
+	}
+	r := s.ranges[i]
+	line := make([]byte, r.Length)
+	if _, err := s.view.f.ReadAt(line, r.Offset); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("jsonl failed reading the underlying file: %w", err)
+	}
+	e, ok := entry(line)
+	if !ok {
+		return nil, fmt.Errorf("%w: entry %d changed since the snapshot", ErrCorrupt, i)
+	}
+	return s.view.apply(e)
+}
+
+// Latest returns the latest entry in the snapshot, or ErrEmpty if it
+// does not contain any entries.
+func (s *Snapshot) Latest() (json.RawMessage, error) {
+	if len(s.ranges) == 0 {
+		return nil, ErrEmpty
+	}
+	if s.view.newestFirst {
+		return s.EntryAt(0)
+	}
+	return s.EntryAt(len(s.ranges) - 1)
+}
+
+// All returns an iterator over the entries in the snapshot, oldest
+// first, as Jsonl.All() does.
+func (s *Snapshot) All() iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		err := s.view.entries(func(_ int64, entry []byte) error {
+			if !yield(append([]byte(nil), entry...), nil) {
+				return errStop
+			}
+			return nil
+		})
+		if err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Close releases the file held open by the snapshot.
+func (s *Snapshot) Close() error {
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,64 @@
+package jsonl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	store := openTemp(t, "snapshot.jsonl")
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`, `{"number":2}`)
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	// Neither writes nor rewrites are seen by the snapshot.
+	writeEntries(t, store, `{"number":3}`, `{"number":4}`)
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if count := snap.Count(); count != 3 {
+		t.Fatalf("expected Count() of (%d), got (%d)", 3, count)
+	}
+	latest, err := snap.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(latest) != `{"number":2}` {
+		t.Fatalf("got wrong entry from Latest(). Expected (%s), got (%s)", `{"number":2}`, latest)
+	}
+	first, err := snap.EntryAt(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != `{"number":0}` {
+		t.Fatalf("got wrong entry from EntryAt(0). Expected (%s), got (%s)", `{"number":0}`, first)
+	}
+	if _, err := snap.EntryAt(3); err == nil {
+		t.Fatal("expected an error from EntryAt() out of range")
+	}
+	var got []string
+	for entry, err := range snap.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(entry))
+	}
+	if want := `{"number":0},{"number":1},{"number":2}`; strings.Join(got, ",") != want {
+		t.Fatalf("got wrong entries from All(). Expected (%s), got (%s)", want, strings.Join(got, ","))
+	}
+	if latest, err := store.ReadBytes(); err != nil || string(latest) != `{"number":4}` {
+		t.Fatalf("got wrong entry from the store. Expected (%s), got (%s, %v)", `{"number":4}`, latest, err)
+	}
+	// An empty snapshot has no latest entry.
+	empty, err := openTemp(t, "empty.jsonl").Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	if _, err := empty.Latest(); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty, got (%v)", err)
+	}
+}
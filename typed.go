@@ -0,0 +1,165 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// LastN decodes the last n non-corrupt entries into values of type
+// T, returned oldest first. Fewer than n values are returned if the
+// store holds fewer than n entries.
+func LastN[T any](j *Jsonl, n int) ([]T, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var raw [][]byte
+	j.mu.RLock()
+	err := j.newestEntries("LastN", func(_ int64, entry []byte) error {
+		raw = append(raw, append([]byte(nil), entry...))
+		if len(raw) == n {
+			return errStop
+		}
+		return nil
+	})
+	j.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]T, len(raw))
+	for i, entry := range raw {
+		if err := json.Unmarshal(entry, &values[len(raw)-1-i]); err != nil {
+			return nil, fmt.Errorf("jsonl: failed to decode entry: %w", err)
+		}
+	}
+	return values, nil
+}
+
+// DecodeLatestN decodes the last n non-corrupt entries into values
+// of type T, returned newest first. Fewer than n values are returned
+// if the store holds fewer than n entries.
+func DecodeLatestN[T any](j *Jsonl, n int) ([]T, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var values []T
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	err := j.newestEntries("DecodeLatestN", func(_ int64, entry []byte) error {
+		var v T
+		if err := json.Unmarshal(entry, &v); err != nil {
+			return fmt.Errorf("jsonl: failed to decode entry: %w", err)
+		}
+		values = append(values, v)
+		if len(values) == n {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// latestDecodedAttempts bounds how many entries LatestDecoded tries.
+const latestDecodedAttempts = 8
+
+// LatestDecoded decodes the latest entry which unmarshals into a
+// value of type T. If the latest entry is valid JSON of the wrong
+// shape for T, earlier entries are tried in turn, up to a bounded
+// number of attempts, recovering from a bad write. ErrEmpty is
+// returned if the store does not contain any entries, or
+// ErrNoValidEntry if it holds only corrupt data.
+func LatestDecoded[T any](j *Jsonl) (T, error) {
+	var v T
+	var lastErr error
+	attempts := 0
+	j.mu.RLock()
+	view, err := j.view("LatestDecoded")
+	if err == nil {
+		err = view.newestEntries(func(_ int64, entry []byte) error {
+			attempts++
+			var candidate T
+			if lastErr = json.Unmarshal(entry, &candidate); lastErr == nil {
+				v = candidate
+				return errStop
+			}
+			if attempts == latestDecodedAttempts {
+				return errStop
+			}
+			return nil
+		})
+	}
+	if err == nil && attempts == 0 {
+		// Tell a wholly corrupt store from an empty one, as ReadBytes()
+		// does.
+		err = view.latest(func([]byte) error { return nil })
+	}
+	j.mu.RUnlock()
+	switch {
+	case errors.Is(err, io.EOF):
+		return v, ErrEmpty
+	case err != nil:
+		return v, err
+	case lastErr != nil:
+		return v, fmt.Errorf("jsonl: no entry in the last %d decoded: %w", attempts, lastErr)
+	}
+	return v, nil
+}
+
+// IterateReuse decodes each non-corrupt entry, oldest first, into
+// target and calls each, avoiding an allocation per entry when
+// iterating large histories. target is reset to its zero value and
+// overwritten for every entry, so each must copy out anything it
+// needs to keep. An error returned by each ends the iteration and is
+// returned.
+func IterateReuse[T any](j *Jsonl, target *T, each func() error) error {
+	view, release, err := j.snapshot("IterateReuse")
+	if err != nil {
+		return err
+	}
+	defer release()
+	return view.entries(func(_ int64, entry []byte) error {
+		var zero T
+		*target = zero
+		if err := json.Unmarshal(entry, target); err != nil {
+			return fmt.Errorf("jsonl: failed to decode entry: %w", err)
+		}
+		return call(each)
+	})
+}
+
+// Update decodes the latest entry into a value of type T, which is
+// the zero value if the store is empty, calls mutate with it, and
+// appends the result, all under one lock so that concurrent updates
+// aren't lost between the read and the write. If mutate returns an
+// error nothing is written and the error is returned. mutate is
+// called with the store locked, so it must not use the store. The
+// entry is decoded as stored, before any WithReadTransform(), so that
+// what the transform hides isn't lost when the result is written.
+func Update[T any](j *Jsonl, mutate func(*T) error) error {
+	_, err := j.write(func() ([]byte, error) {
+		var v T
+		latest, err := j.readStored("Update")
+		switch {
+		case errors.Is(err, io.EOF):
+		case err != nil:
+			return nil, err
+		default:
+			if err := json.Unmarshal(latest, &v); err != nil {
+				return nil, fmt.Errorf("jsonl: failed to decode the latest entry: %w", err)
+			}
+		}
+		if err := call(func() error { return mutate(&v) }); err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return j.prepareLimit(b, j.cfg.maxEntrySize())
+	})
+	return err
+}
@@ -0,0 +1,241 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLastN(t *testing.T) {
+	type Entry struct {
+		V int `json:"number"`
+	}
+	store := openTemp(t, "lastn.jsonl")
+	writer := json.NewEncoder(store)
+	for i := 0; i < 10; i++ {
+		if err := writer.Encode(&Entry{V: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A corrupt tail is skipped.
+	if _, err := store.f.Write([]byte(`{"number":`)); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := LastN[Entry](store, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 3 {
+		t.Fatalf("expected (%d) entries, got (%d)", 3, len(last))
+	}
+	for i, want := range []int{7, 8, 9} {
+		if last[i].V != want {
+			t.Fatalf("got wrong entry (%d). Expected (%d), got (%d)", i, want, last[i].V)
+		}
+	}
+
+	all, err := LastN[Entry](store, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 10 || all[0].V != 0 {
+		t.Fatalf("expected all (%d) entries oldest first, got (%+v)", 10, all)
+	}
+}
+
+func TestLatestDecoded(t *testing.T) {
+	type Config struct {
+		Key   string `json:"key"`
+		Count int    `json:"count"`
+	}
+	store := openTemp(t, "latestdecoded.jsonl")
+	if _, err := LatestDecoded[Config](store); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty on an empty store, got (%v)", err)
+	}
+	corrupt := openTemp(t, "corrupt.jsonl")
+	if _, err := corrupt.f.Write([]byte("{\"key\":\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LatestDecoded[Config](corrupt); !errors.Is(err, ErrNoValidEntry) {
+		t.Fatalf("expected ErrNoValidEntry on a corrupt store, got (%v)", err)
+	}
+	writeEntries(t, store,
+		`{"key":"old","count":1}`,
+		`{"key":"good","count":2}`,
+		// Valid JSON, but the wrong shape for Config.
+		`{"key":["not","a","string"],"count":3}`,
+		`[4]`,
+	)
+	config, err := LatestDecoded[Config](store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Key != "good" || config.Count != 2 {
+		t.Fatalf("expected the latest correctly-shaped entry, got (%+v)", config)
+	}
+
+	for i := 0; i < latestDecodedAttempts; i++ {
+		writeEntries(t, store, `"wrong"`)
+	}
+	if _, err := LatestDecoded[Config](store); err == nil {
+		t.Fatal("expected an error once the attempts were exhausted")
+	}
+}
+
+func TestIterateReuse(t *testing.T) {
+	type Entry struct {
+		V    int    `json:"number"`
+		Name string `json:"name"`
+	}
+	store := openTemp(t, "reuse.jsonl")
+	writeEntries(t, store, `{"number":1,"name":"one"}`, `{"number":2}`, `{"number":3}`)
+	var target Entry
+	sum, names := 0, 0
+	err := IterateReuse(store, &target, func() error {
+		sum += target.V
+		if target.Name != "" {
+			names++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 6 {
+		t.Fatalf("got wrong sum from IterateReuse(). Expected (6), got (%d)", sum)
+	}
+	if names != 1 {
+		t.Fatalf("expected target to be reset between entries, got (%d) names", names)
+	}
+	errStopped := errors.New("stopped")
+	if err := IterateReuse(store, &target, func() error { return errStopped }); !errors.Is(err, errStopped) {
+		t.Fatalf("expected the error from each, got (%v)", err)
+	}
+}
+
+func BenchmarkIterateReuse(b *testing.B) {
+	store := benchStore(b, 1000, []byte(`{"number":1}`))
+	var target struct {
+		V int `json:"number"`
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sum := 0
+		err := IterateReuse(store, &target, func() error {
+			sum += target.V
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeLatestN(t *testing.T) {
+	type Entry struct {
+		V int `json:"number"`
+	}
+	store := openTemp(t, "latestn.jsonl")
+	writer := json.NewEncoder(store)
+	for i := 0; i < 10; i++ {
+		if err := writer.Encode(&Entry{V: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest, err := DecodeLatestN[Entry](store, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 3 {
+		t.Fatalf("expected (%d) entries, got (%d)", 3, len(latest))
+	}
+	for i, want := range []int{9, 8, 7} {
+		if latest[i].V != want {
+			t.Fatalf("got wrong entry (%d) from DecodeLatestN(). Expected (%d), got (%d)", i, want, latest[i].V)
+		}
+	}
+	all, err := DecodeLatestN[Entry](store, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 10 {
+		t.Fatalf("expected (%d) entries, got (%d)", 10, len(all))
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	type Entry struct {
+		Counter int `json:"counter"`
+	}
+	store := openTemp(t, "update.jsonl")
+	const updates = 50
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Update(store, func(e *Entry) error {
+				e.Counter++
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	latest, err := LatestDecoded[Entry](store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Counter != updates {
+		t.Fatalf("expected counter of (%d), got (%d)", updates, latest.Counter)
+	}
+	// An error from mutate writes nothing.
+	errAbort := errors.New("abort")
+	err = Update(store, func(e *Entry) error {
+		e.Counter = 0
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected the error from mutate, got (%v)", err)
+	}
+	if count, err := store.Count(); err != nil || count != updates {
+		t.Fatalf("expected Count() of (%d), got (%d, %v)", updates, count, err)
+	}
+
+	// The read transform redacts the secret, which Update must keep.
+	type Account struct {
+		Secret  string `json:"secret"`
+		Counter int    `json:"counter"`
+	}
+	redact := func(entry []byte) ([]byte, error) {
+		var m map[string]any
+		if err := json.Unmarshal(entry, &m); err != nil {
+			return nil, err
+		}
+		delete(m, "secret")
+		return json.Marshal(m)
+	}
+	redacted := openTemp(t, "redacted.jsonl", WithReadTransform(redact))
+	writeEntries(t, redacted, `{"secret":"s3cr3t","counter":0}`)
+	err = Update(redacted, func(a *Account) error {
+		a.Counter++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(redacted.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"secret":"s3cr3t","counter":1}`; !strings.HasSuffix(string(b), want+"\n") {
+		t.Fatalf("expected Update() to write (%s), got (%q)", want, b)
+	}
+}
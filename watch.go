@@ -0,0 +1,379 @@
+package jsonl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// WatchLatest decodes each entry appended to the store after the
+// call into a value of type T and sends it on the returned value
+// channel, in the order the entries were written. Decode and read
+// errors are sent on the error channel. Both channels are closed
+// once ctx is done. Only appends made through j are observed.
+func WatchLatest[T any](ctx context.Context, j *Jsonl) (<-chan T, <-chan error) {
+	values := make(chan T)
+	errs := make(chan error)
+	j.mu.RLock()
+	view, err := j.view("WatchLatest")
+	changed := j.changed
+	generation, appended := j.generation, j.appended
+	sub := j.subscribe(view.size)
+	j.mu.RUnlock()
+	pos := view.size
+	go func() {
+		defer close(values)
+		defer close(errs)
+		defer j.unsubscribe(sub)
+		if err != nil {
+			sendErr(ctx, errs, err)
+			return
+		}
+		// deliver decodes and sends the entry at off, returning an
+		// error only once ctx is done.
+		deliver := func(off int64, e []byte) error {
+			var v T
+			e, err := j.readTransform(e)
+			if err == nil {
+				if err = json.Unmarshal(e, &v); err != nil {
+					err = fmt.Errorf("jsonl: failed to decode entry: %w", err)
+				}
+			}
+			if err != nil {
+				if !sendErr(ctx, errs, err) {
+					return ctx.Err()
+				}
+				j.received(sub, off)
+				return nil
+			}
+			select {
+			case values <- v:
+				j.received(sub, off)
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+			j.mu.RLock()
+			view, err := j.view("WatchLatest")
+			changed = j.changed
+			n := j.appended - appended
+			appended = j.appended
+			if j.generation != generation && !j.cfg.newestFirst {
+				// The file was rewritten, so previous offsets are
+				// meaningless. Entries appended since are delivered.
+				generation, pos = j.generation, j.rewritten
+				j.resubscribe(sub, pos)
+			}
+			var release func()
+			if err == nil {
+				release = j.acquire()
+			}
+			j.mu.RUnlock()
+			if err != nil {
+				if !sendErr(ctx, errs, err) {
+					return
+				}
+				continue
+			}
+			if j.cfg.newestFirst {
+				err = j.headEntries(view, sub, n, deliver)
+			} else {
+				view.start = pos
+				err = view.scanForward(func(off int64, line []byte) error {
+					pos = off + int64(len(line)) + 1
+					if e, ok := entry(line); ok {
+						return deliver(off, e)
+					}
+					return nil
+				})
+			}
+			release()
+			if err != nil && !sendErr(ctx, errs, err) {
+				return
+			}
+		}
+	}()
+	return values, errs
+}
+
+// StreamTo writes every non-corrupt entry in the store to w, each
+// followed by a newline, then continues writing entries as they are
+// appended until ctx is done, like tail -f. It returns ctx.Err() once
+// ctx is done, or the first error reading the store or writing to w.
+// Only appends made through j are observed.
+func (j *Jsonl) StreamTo(ctx context.Context, w io.Writer) error {
+	j.mu.RLock()
+	view, err := j.view("StreamTo")
+	changed := j.changed
+	generation, appended := j.generation, j.appended
+	// Entries already in the store when streaming starts don't hold
+	// back writers.
+	sub := j.subscribe(view.size)
+	var release func()
+	if err == nil {
+		release = j.acquire()
+	}
+	j.mu.RUnlock()
+	if err != nil {
+		j.unsubscribe(sub)
+		return err
+	}
+	defer j.unsubscribe(sub)
+	// write writes the entry at off to w.
+	write := func(off int64, e []byte) error {
+		e, err := j.readTransform(e)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(e[:len(e):len(e)], '\n')); err != nil {
+			return err
+		}
+		j.received(sub, off)
+		return ctx.Err()
+	}
+	pos := view.start
+	// n is the number of entries appended since the last scan, or -1
+	// before the first, which streams the whole store.
+	n := -1
+	for {
+		if j.cfg.newestFirst && n >= 0 {
+			err = j.headEntries(view, sub, n, write)
+		} else {
+			view.start = pos
+			err = view.scanForward(func(off int64, line []byte) error {
+				pos = off + int64(len(line)) + 1
+				if e, ok := entry(line); ok {
+					return write(off, e)
+				}
+				return nil
+			})
+		}
+		release()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+		j.mu.RLock()
+		view, err = j.view("StreamTo")
+		changed = j.changed
+		n = j.appended - appended
+		appended = j.appended
+		if j.generation != generation && !j.cfg.newestFirst {
+			// The file was rewritten, so previous offsets are
+			// meaningless. Entries appended since are streamed.
+			generation, pos = j.generation, j.rewritten
+			j.resubscribe(sub, pos)
+		}
+		if err == nil {
+			release = j.acquire()
+		}
+		j.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// headEntries calls fn with each of the n entries most recently
+// appended through j to the newest-first store in view, oldest first.
+// Every write to a newest-first store rewrites it, so those entries
+// are found at its head rather than past an offset. If fewer remain,
+// such as after Compact(), those remaining are passed, and s is
+// restarted, as it will never receive the rest.
+func (j *Jsonl) headEntries(v view, s *subscriber, n int, fn func(off int64, entry []byte) error) error {
+	if n <= 0 {
+		return nil
+	}
+	type head struct {
+		off   int64
+		entry []byte
+	}
+	var heads []head
+	err := v.scanForward(func(off int64, line []byte) error {
+		if e, ok := entry(line); ok {
+			heads = append(heads, head{off, append([]byte(nil), e...)})
+			if len(heads) == n {
+				return errStop
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(heads) < n {
+		j.mu.RLock()
+		j.resubscribe(s, 0)
+		j.mu.RUnlock()
+	}
+	for i := len(heads) - 1; i >= 0; i-- {
+		if err := fn(heads[i].off, heads[i].entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscriber tracks how far a subscriber paced WithBackpressure()
+// lags behind the writers. Its fields are guarded by j.subMu.
+type subscriber struct {
+	// pending is the number of entries written through j at or after
+	// offset from which the subscriber has yet to receive.
+	pending int
+	from    int64
+}
+
+// subscribe registers a subscriber receiving the entries from offset
+// from, returning nil unless opened WithBackpressure(). The caller
+// must hold j.mu, so that no write lands between reading the size of
+// the file and registering.
+func (j *Jsonl) subscribe(from int64) *subscriber {
+	if j.cfg.backpressure <= 0 {
+		return nil
+	}
+	if j.cfg.newestFirst {
+		// Offsets move with every write, so every entry received
+		// counts.
+		from = 0
+	}
+	s := &subscriber{from: from}
+	j.subMu.Lock()
+	defer j.subMu.Unlock()
+	if j.subs == nil {
+		j.subs = make(map[*subscriber]struct{})
+	}
+	j.subs[s] = struct{}{}
+	return s
+}
+
+// unsubscribe removes s, releasing any writers it was holding back.
+func (j *Jsonl) unsubscribe(s *subscriber) {
+	if s == nil {
+		return
+	}
+	j.subMu.Lock()
+	defer j.subMu.Unlock()
+	delete(j.subs, s)
+	j.progressed()
+}
+
+// resubscribe restarts s from offset from after the file was
+// rewritten, forgetting the entries it had yet to receive. The
+// caller must hold j.mu.
+func (j *Jsonl) resubscribe(s *subscriber, from int64) {
+	if s == nil {
+		return
+	}
+	if j.cfg.newestFirst {
+		from = 0
+	}
+	j.subMu.Lock()
+	defer j.subMu.Unlock()
+	s.pending, s.from = 0, from
+	j.progressed()
+}
+
+// received records that s received the entry at offset off.
+func (j *Jsonl) received(s *subscriber, off int64) {
+	if s == nil {
+		return
+	}
+	j.subMu.Lock()
+	defer j.subMu.Unlock()
+	// Entries written by other handles were never counted.
+	if off >= s.from && s.pending > 0 {
+		s.pending--
+		j.progressed()
+	}
+}
+
+// published records that n entries were appended for the subscribers
+// to receive. The caller must hold j.mu for writing.
+func (j *Jsonl) published(n int) {
+	if j.cfg.backpressure <= 0 {
+		return
+	}
+	j.subMu.Lock()
+	defer j.subMu.Unlock()
+	for s := range j.subs {
+		s.pending += n
+	}
+}
+
+// progressed wakes writers waiting for subscribers. The caller must
+// hold j.subMu.
+func (j *Jsonl) progressed() {
+	if j.progress != nil {
+		close(j.progress)
+		j.progress = nil
+	}
+}
+
+// awaitSubscribers waits until no subscriber lags the WithBackpressure()
+// depth or more entries behind, returning ErrBackpressure if one
+// hasn't caught up within the timeout.
+func (j *Jsonl) awaitSubscribers() error {
+	depth := j.cfg.backpressure
+	if depth <= 0 {
+		return nil
+	}
+	var timer *time.Timer
+	for {
+		j.subMu.Lock()
+		lagging := false
+		for s := range j.subs {
+			if s.pending >= depth {
+				lagging = true
+				break
+			}
+		}
+		if !lagging {
+			j.subMu.Unlock()
+			return nil
+		}
+		if j.progress == nil {
+			j.progress = make(chan struct{})
+		}
+		progress := j.progress
+		j.subMu.Unlock()
+		if timer == nil {
+			timer = time.NewTimer(j.cfg.backpressureTimeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-progress:
+		case <-timer.C:
+			return fmt.Errorf("%w after %s", ErrBackpressure, j.cfg.backpressureTimeout)
+		}
+	}
+}
+
+// notify wakes anything waiting for the file to change. The caller
+// must hold j.mu for writing.
+func (j *Jsonl) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// sendErr sends err on errs, reporting false if ctx is done first.
+func sendErr(ctx context.Context, errs chan<- error, err error) bool {
+	select {
+	case errs <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,326 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchLatest(t *testing.T) {
+	type Config struct {
+		Version int `json:"version"`
+	}
+	store := openTemp(t, "watch.jsonl")
+	writeEntries(t, store, `{"version":0}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values, errs := WatchLatest[Config](ctx, store)
+
+	writer := json.NewEncoder(store)
+	for i := 1; i <= 3; i++ {
+		if err := writer.Encode(&Config{Version: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for want := 1; want <= 3; want++ {
+		select {
+		case v := <-values:
+			if v.Version != want {
+				t.Fatalf("got wrong version. Expected (%d), got (%d)", want, v.Version)
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for version (%d)", want)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-values:
+		if ok {
+			t.Fatal("expected no further values after cancellation")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("value channel was not closed after cancellation")
+	}
+}
+
+func TestWatchLatestAfterRewrite(t *testing.T) {
+	type Entry struct {
+		Old int `json:"old,omitempty"`
+		New int `json:"new"`
+	}
+	store := openTemp(t, "rewrite.jsonl")
+	for i := 0; i < 8; i++ {
+		writeEntries(t, store, fmt.Sprintf(`{"old":%d,"padding":"%s"}`, i+1, strings.Repeat("x", 64)))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values, errs := WatchLatest[Entry](ctx, store)
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	// Enough is appended to grow the file past where the watcher was.
+	for i := 0; i < 8; i++ {
+		writeEntries(t, store, fmt.Sprintf(`{"new":%d,"padding":"%s"}`, i, strings.Repeat("y", 64)))
+	}
+	for want := 0; want < 8; want++ {
+		select {
+		case v := <-values:
+			if v.Old != 0 || v.New != want {
+				t.Fatalf("got wrong entry. Expected new (%d), got (%+v)", want, v)
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for new (%d)", want)
+		}
+	}
+}
+
+func TestStreamTo(t *testing.T) {
+	store := openTemp(t, "stream.jsonl")
+	writeEntries(t, store, `{"number":0}`, `{"number":1}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- store.StreamTo(ctx, pw)
+		_ = pw.Close()
+	}()
+	lines := bufio.NewScanner(pr)
+	expect := func(want string) {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("stream ended before (%s): %v", want, lines.Err())
+		}
+		if lines.Text() != want {
+			t.Fatalf("got wrong entry from StreamTo(). Expected (%s), got (%s)", want, lines.Text())
+		}
+	}
+	expect(`{"number":0}`)
+	expect(`{"number":1}`)
+	writeEntries(t, store, `{"number":2}`, `{"number":3}`)
+	expect(`{"number":2}`)
+	expect(`{"number":3}`)
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled from StreamTo(), got (%v)", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("StreamTo() did not return after cancellation")
+	}
+}
+
+func TestStreamToAfterRewrite(t *testing.T) {
+	store := openTemp(t, "rewrite.jsonl")
+	for i := 0; i < 8; i++ {
+		writeEntries(t, store, fmt.Sprintf(`{"old":%d,"padding":"%s"}`, i, strings.Repeat("x", 64)))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(store.StreamTo(ctx, pw))
+	}()
+	lines := bufio.NewScanner(pr)
+	for i := 0; i < 8; i++ {
+		if !lines.Scan() {
+			t.Fatalf("stream ended early: %v", lines.Err())
+		}
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	// Enough is appended to grow the file past where the stream was.
+	for i := 0; i < 8; i++ {
+		writeEntries(t, store, fmt.Sprintf(`{"new":%d,"padding":"%s"}`, i, strings.Repeat("y", 64)))
+	}
+	for i := 0; i < 8; i++ {
+		want := fmt.Sprintf(`{"new":%d,"padding":"%s"}`, i, strings.Repeat("y", 64))
+		if !lines.Scan() {
+			t.Fatalf("stream ended before (%s): %v", want, lines.Err())
+		}
+		if lines.Text() != want {
+			t.Fatalf("got wrong entry from StreamTo(). Expected (%s), got (%s)", want, lines.Text())
+		}
+	}
+}
+
+func TestWithBackpressure(t *testing.T) {
+	type Config struct {
+		Version int `json:"version"`
+	}
+	store := openTemp(t, "backpressure.jsonl", WithBackpressure(2, 5*time.Second))
+	writeEntries(t, store, `{"version":0}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values, errs := WatchLatest[Config](ctx, store)
+
+	// The subscriber may lag two entries behind before writes block.
+	writeEntries(t, store, `{"version":1}`, `{"version":2}`)
+	written := make(chan error, 1)
+	go func() {
+		_, err := store.Write([]byte(`{"version":3}`))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("expected the write to block while the subscriber lags, got (%v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	receive := func(want int) {
+		t.Helper()
+		select {
+		case v := <-values:
+			if v.Version != want {
+				t.Fatalf("got wrong version. Expected (%d), got (%d)", want, v.Version)
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for version (%d)", want)
+		}
+	}
+	receive(1)
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write did not resume after the subscriber caught up")
+	}
+	for want := 2; want <= 3; want++ {
+		receive(want)
+	}
+
+	// A subscriber which stops receiving holds writers back only until
+	// the timeout.
+	store = openTemp(t, "stalled.jsonl", WithBackpressure(1, 20*time.Millisecond))
+	WatchLatest[Config](ctx, store)
+	writeEntries(t, store, `{"version":0}`)
+	if _, err := store.Write([]byte(`{"version":1}`)); !errors.Is(err, ErrBackpressure) {
+		t.Fatalf("expected ErrBackpressure, got (%v)", err)
+	}
+	if got, err := collect(store); err != nil {
+		t.Fatal(err)
+	} else if len(got) != 1 {
+		t.Fatalf("expected the timed out write not to be written, got (%d) entries", len(got))
+	}
+
+	// Once the subscriber leaves, writes no longer wait for it.
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := store.Write([]byte(`{"version":1}`))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrBackpressure) || time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWatchLatestWithReadTransform(t *testing.T) {
+	type Config struct {
+		Version int `json:"version"`
+	}
+	migrate := func(entry []byte) ([]byte, error) {
+		return bytes.Replace(entry, []byte(`"v":`), []byte(`"version":`), 1), nil
+	}
+	store := openTemp(t, "watch.jsonl", WithReadTransform(migrate))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values, errs := WatchLatest[Config](ctx, store)
+
+	writeEntries(t, store, `{"v":1}`)
+	select {
+	case v := <-values:
+		if v.Version != 1 {
+			t.Fatalf("got wrong version. Expected (%d), got (%d)", 1, v.Version)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the transformed entry")
+	}
+}
+
+func TestWatchNewestFirst(t *testing.T) {
+	type Config struct {
+		Version int `json:"version"`
+	}
+	store := openTemp(t, "newest.jsonl", WithNewestFirst(), WithBackpressure(2, time.Second))
+	writeEntries(t, store, `{"version":0}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values, errs := WatchLatest[Config](ctx, store)
+	written := make(chan error, 1)
+	go func() {
+		for i := 1; i <= 5; i++ {
+			if _, err := store.Write([]byte(fmt.Sprintf(`{"version":%d}`, i))); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+	for want := 1; want <= 5; want++ {
+		select {
+		case v := <-values:
+			if v.Version != want {
+				t.Fatalf("got wrong version. Expected (%d), got (%d)", want, v.Version)
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for version (%d)", want)
+		}
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	// StreamTo() streams the store as stored, then the entries written
+	// since in the order they were written.
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(store.StreamTo(ctx, pw))
+	}()
+	lines := bufio.NewScanner(pr)
+	expect := func(want string) {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("stream ended before (%s): %v", want, lines.Err())
+		}
+		if lines.Text() != want {
+			t.Fatalf("got wrong entry from StreamTo(). Expected (%s), got (%s)", want, lines.Text())
+		}
+	}
+	for i := 5; i >= 0; i-- {
+		expect(fmt.Sprintf(`{"version":%d}`, i))
+	}
+	writeEntries(t, store, `{"version":6}`, `{"version":7}`)
+	expect(`{"version":6}`)
+	expect(`{"version":7}`)
+}